@@ -7,13 +7,17 @@ import (
 	"bytes"
 	"flag"
 	"fmt"
-	"os"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 )
 
 // builds the main Usage string
 func usageMain() string {
-	return "Usage: imgp [-i[n] files...] [-d[o] operations...] [-o[ut] (j|jpg|jpeg|p|png)]\n\n" +
+	return "Usage: imgp [-i[n] files...] [-d[o] operations...] [-o[ut] (j|jpg|jpeg|p|png|g|gif)] [-outdir dir]\n" +
+		"\t[-j n] [-manifest file] [-maxpixels n] [-maxdim n] [-describe] [-info] [-preset name] [-presets file]\n" +
+		"   or: imgp -compare a b [-o diff.(jpg|png|gif)]\n\n" +
 
 		"\t-in (or -i for short) specifies the input file(s).\n" +
 		"\t\tThe input files can be either jpg, gif, or png.\n" +
@@ -21,26 +25,71 @@ func usageMain() string {
 		"\t\tthe input files will be read from stdin, one file per line.\n\n" +
 
 		"\t-out (or -o) specifies the output format of the file(s).\n" +
-		"\t\tThe default output is png.\n" +
+		"\t\tThe default output is png. Gif output is limited to a 256 color palette,\n" +
+		"\t\tso other colors are approximated (with dithering).\n" +
 		"\t\tOnly one output format can be specified, and this chosen\n" +
 		"\t\textension will be appended onto each of the input files.\n" +
 		"\t\tE.g. \"imgproc -i ./bar/foo.jpg -o p\" will result in\n" +
 		"\t\ta file named \"foo.jpg.png\" being placed in the folder \"./bar/\"\n\n" +
 
+		"\t-outdir places the output file(s) into the given directory (creating it if needed),\n" +
+		"\t\tinstead of alongside the input file(s). E.g. \"imgp -i ./bar/foo.jpg -outdir ./out\"\n" +
+		"\t\twill result in a file named \"foo.jpg.png\" being placed in the folder \"./out/\"\n\n" +
+
 		"\t-do (or -d) specifies the operations(s) to apply to each image.\n" +
 		"\t\tThe operations must be specified as list, separated by '+'.\n" +
 		"\t\tEach operation must be in the form <keyword> par1=v1 par2=v2 ...\n" +
 		"\t\tE.g. \"imgp -i file1 -d scale s=2 + flip o=vert -o p\")\n" +
 		"\t\tIf only image format conversion is required, no operations need to be specified.\n\n" +
 
+		"\t-j sets the number of files processed in parallel (by default, the number of CPUs).\n" +
+		"\t\tIf any file fails, the remaining files are still processed: the failures are\n" +
+		"\t\treported at the end, and imgp exits with a non-zero status.\n\n" +
+
+		"\t-manifest writes a JSON record of each processed file to the given file: its input and\n" +
+		"\t\toutput paths, output dimensions, the operations applied, whether it succeeded\n" +
+		"\t\t(and if not, the error), and the time taken.\n\n" +
+
+		"\t-maxpixels and -maxdim limit the size of the input images, to guard against\n" +
+		"\t\tmaliciously large (\"decompression bomb\") images exhausting memory.\n" +
+		"\t\t-maxpixels limits the area (width x height), and -maxdim limits the width and height.\n" +
+		"\t\tImages exceeding a limit are rejected before being decoded. By default, there is no limit.\n\n" +
+
+		"\t-describe prints the parsed operations (in the order they will be applied), then exits.\n" +
+		"\t\tE.g. \"imgp -d scale s=2 + flip o=vert -describe\" prints \"scale(s=2.0) -> flip(vert)\"\n\n" +
+
+		"\t-info prints the format, dimensions, color model and transparency of each input file,\n" +
+		"\t\twithout applying any operations or writing any output.\n\n" +
+
+		"\t-compare compares two images of the same size, printing their PSNR and SSIM,\n" +
+		"\t\tinstead of processing any images. If -o names a file, the difference of\n" +
+		"\t\tthe two images (amplified, to make small differences visible) is written to it,\n" +
+		"\t\tin the format given by its extension. E.g. \"imgp -compare a.png b.png -o diff.png\"\n\n" +
+
+		"\t-preset applies a named preset: a predefined chain of operations.\n" +
+		"\t\tAny operations given by -do are applied after the preset's operations.\n" +
+		"\t-presets reads additional presets from a config file, one per line, in the form:\n" +
+		"\t\t<name> <operations...> (e.g. \"small scale s=0.5 + flip o=vert\").\n" +
+		"\t\tBlank lines, and lines starting with '#', are ignored.\n\n" +
+
+		"\tSupported Presets:\n" +
+		listSupportedPresets() + "\n" +
+
 		"\tSupported Operations: (run \"imgp -h[elp] operation\" for more details on each operation).\n" +
 		listSupportedOps() + "\n"
 }
 
+// list the operations, in order of their keywords
 func listSupportedOps() string {
+	keywords := make([]string, 0, len(supported_ops))
+	for keyword := range supported_ops {
+		keywords = append(keywords, keyword)
+	}
+	sort.Strings(keywords)
+
 	res := bytes.NewBufferString("")
-	for keyword, op := range supported_ops {
-		res.WriteString(fmt.Sprintln("\t\t", keyword, op.Desc))
+	for _, keyword := range keywords {
+		res.WriteString(fmt.Sprintln("\t\t", keyword, supported_ops[keyword].Desc))
 	}
 	return res.String()
 }
@@ -68,10 +117,20 @@ func (e *EmptyWriter) Write(p []byte) (n int, err error) {
 }
 
 // preprocess args:
-// group multi-value args into one field:
+// group multi-value args into one field.
+// Empty args (e.g. from shell expansion of an unset variable) are skipped.
 func preprocessArgs(args []string) []string {
 	res := make([]string, 0)
 
+	// drop the empty args (into a copy, leaving the caller's args untouched):
+	nonEmpty := make([]string, 0, len(args))
+	for _, arg := range args {
+		if arg != "" {
+			nonEmpty = append(nonEmpty, arg)
+		}
+	}
+	args = nonEmpty
+
 	lastIndexStart := 0 // location of the start of the current multi-arg
 	for index, arg := range args {
 		if arg[0] == '-' {
@@ -93,8 +152,20 @@ func preprocessArgs(args []string) []string {
 	return res
 }
 
-// parse command line args
-func parseArgs() (input, operations, help strArr, output string, err error) {
+// the parsed command line options
+type options struct {
+	input, operations, help strArr
+	compare                 strArr
+	output, outDir          string
+	manifest                string
+	preset, presetsFile     string
+	describe, info          bool
+	jobs                    int
+	limits                  sizeLimits
+}
+
+// parse command line args (excluding the program name)
+func parseArgs(args []string) (opts options, err error) {
 
 	const (
 		defaultOutType = "png"
@@ -105,42 +176,69 @@ func parseArgs() (input, operations, help strArr, output string, err error) {
 	flags.SetOutput(&EmptyWriter{}) // suppress output. We have custom error printing.
 
 	// out and o share the variable: output
-	flags.StringVar(&output, "out", defaultOutType, usage)
-	flags.StringVar(&output, "o", defaultOutType, usage)
+	flags.StringVar(&opts.output, "out", defaultOutType, usage)
+	flags.StringVar(&opts.output, "o", defaultOutType, usage)
 
 	// in and i share the variable: input
-	flags.Var(&input, "in", usage)
-	flags.Var(&input, "i", usage)
+	flags.Var(&opts.input, "in", usage)
+	flags.Var(&opts.input, "i", usage)
 
 	// help and h share the variable: help
-	flags.Var(&help, "help", usage)
-	flags.Var(&help, "h", usage)
+	flags.Var(&opts.help, "help", usage)
+	flags.Var(&opts.help, "h", usage)
 
 	// do and d share the variable: operations
-	flags.Var(&operations, "do", usage)
-	flags.Var(&operations, "d", usage)
+	flags.Var(&opts.operations, "do", usage)
+	flags.Var(&opts.operations, "d", usage)
+
+	// compare takes the two images to compare
+	flags.Var(&opts.compare, "compare", usage)
+
+	// outdir, j, manifest, describe, info, preset, presets, maxpixels and maxdim have no short forms
+	flags.StringVar(&opts.outDir, "outdir", "", usage)
+	flags.IntVar(&opts.jobs, "j", runtime.NumCPU(), usage)
+	flags.StringVar(&opts.manifest, "manifest", "", usage)
+	flags.BoolVar(&opts.describe, "describe", false, usage)
+	flags.BoolVar(&opts.info, "info", false, usage)
+	flags.StringVar(&opts.preset, "preset", "", usage)
+	flags.StringVar(&opts.presetsFile, "presets", "", usage)
+	flags.IntVar(&opts.limits.maxPixels, "maxpixels", 0, usage)
+	flags.IntVar(&opts.limits.maxDim, "maxdim", 0, usage)
 
-	err = flags.Parse(preprocessArgs(os.Args[1:]))
+	err = flags.Parse(preprocessArgs(args))
 	return
 }
 
+// a single operation parsed from the command line:
+// the operation keyword, and its args in flag form (i.e. [-keyword -par1=v1 -par2=v2 ...])
+type opArgs struct {
+	keyword string
+	args    []string
+}
+
 // convert an array of the form:
 //	 [ keyword1 par11=v11 par12=v12 ... + keyword2 par21=v21 ... ... ]
-// into the (map) form
-//   keyword1: [-par11=v11 -par12=v12 ... ]
-//	 keyword2: [-par21=v21 ... ]
-func collectArgs(ops []string) map[string][]string {
-	res := make(map[string][]string)
-
-	// prepend a dash to each elem in ops:
-	for i := range ops { 
-		ops[i] = "-" + ops[i]
+// into the (ordered) form
+//   [ {keyword1, [-keyword1 -par11=v11 -par12=v12 ... ]},
+//	   {keyword2, [-keyword2 -par21=v21 ... ]} ]
+// The operations are kept in the order they were specified,
+// since the result of composing operations depends on their order.
+func collectArgs(ops []string) []opArgs {
+	res := make([]opArgs, 0)
+
+	// prepend a dash to each elem in ops (into a copy, leaving the caller's ops untouched):
+	flagged := make([]string, len(ops))
+	for i, op := range ops {
+		flagged[i] = "-" + op
 	}
-	
+	ops = flagged
+
 	last := 0 // location of the start of the current operation
 	for cur, arg := range ops {
 		if arg == "-+" {
-			res[ops[last][1:]] = ops[last:cur]
+			if last < cur {
+				res = append(res, opArgs{keyword: ops[last][1:], args: ops[last:cur]})
+			}
 			last = cur + 1
 		}
 	}
@@ -148,8 +246,81 @@ func collectArgs(ops []string) map[string][]string {
 	// deal with remaining operation
 	end := len(ops)
 	if last < end  {
-		res[ops[last][1:]] = ops[last:end]
+		res = append(res, opArgs{keyword: ops[last][1:], args: ops[last:end]})
 	}
 	return res
 }
 
+// describe the operations as a normalized string, e.g.
+//   "blur(e=clamp,r=2,v=1.0) -> flip(vert)"
+// The operations are listed in the order they are applied, each with its parameters as parsed:
+// see describeOperation.
+func describeOperations(ops []string) string {
+	parsed := collectArgs(ops)
+	if len(parsed) == 0 {
+		return "ident()" // no operations: the image is unchanged
+	}
+
+	descs := make([]string, len(parsed))
+	for i, op := range parsed {
+		descs[i] = describeOperation(op)
+	}
+	return strings.Join(descs, " -> ")
+}
+
+// describe a single operation, with its parameters as parsed by its factory, sorted by name.
+// Defaults are filled in, except those left at zero (which mean "not given", e.g. w and h of scale s=2),
+// and floats are normalized to include a decimal point (e.g. v=1 is described as v=1.0).
+// An operation with a single parameter is described by its value alone, e.g. flip(vert).
+// Operations whose parameters can't be parsed (e.g. which don't use parseOpArgs) are described
+// by their parameters as given.
+func describeOperation(op opArgs) string {
+	var params []string
+	parsed := false
+	if supported, found := supported_ops[op.keyword]; found {
+		describing.Lock()
+		parsedOpArgs = func(flags *flag.FlagSet) {
+			params, parsed = describeParams(flags), true
+		}
+		_, err := supported.Factory(op.args)
+		parsedOpArgs = nil
+		describing.Unlock()
+		parsed = parsed && err == nil
+	}
+
+	if !parsed {
+		params = make([]string, 0, len(op.args)-1)
+		for _, arg := range op.args[1:] {
+			params = append(params, arg[1:]) // strip the dash
+		}
+		sort.Strings(params)
+	}
+	return op.keyword + "(" + strings.Join(params, ",") + ")"
+}
+
+// describe the parsed parameters of an operation, as per describeOperation.
+func describeParams(flags *flag.FlagSet) []string {
+	var params, values []string
+	defined := 0
+	flags.VisitAll(func(f *flag.Flag) { // in order of their names
+		defined++
+		value := f.Value.String()
+		if getter, ok := f.Value.(flag.Getter); ok {
+			if v, isFloat := getter.Get().(float64); isFloat {
+				value = strconv.FormatFloat(v, 'f', -1, 64)
+				if !strings.Contains(value, ".") {
+					value += ".0"
+				}
+			}
+		}
+		if f.Value.String() == f.DefValue && (f.DefValue == "0" || f.DefValue == "" || f.DefValue == "false") {
+			return // left unset
+		}
+		params = append(params, f.Name+"="+value)
+		values = append(values, value)
+	})
+	if defined == 1 {
+		return values
+	}
+	return params
+}
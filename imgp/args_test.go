@@ -0,0 +1,91 @@
+// Test file for args.go
+
+package main
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestDescribeOperationsIsNormalized(t *testing.T) {
+	ops := strings.Fields("blur v=1 r=2 + sharpen a=0.5 + flip o=horiz + scale s=2")
+	exp := "blur(e=clamp,r=2,v=1.0) -> sharpen(a=0.5,e=clamp,m=unsharp,r=1) -> flip(horiz) -> scale(s=2.0)"
+	if act := describeOperations(ops); act != exp {
+		t.Errorf("describeOperations: exp=%q, act=%q", exp, act)
+	}
+}
+
+func TestDescribeOperationsFillsInDefaults(t *testing.T) {
+	ops := strings.Fields("blur + equalize + gray")
+	exp := "blur(e=clamp,r=1,v=1.0) -> equalize(luma) -> gray()"
+	if act := describeOperations(ops); act != exp {
+		t.Errorf("describeOperations: exp=%q, act=%q", exp, act)
+	}
+}
+
+func TestDescribeOperationsOfEmptyPipeline(t *testing.T) {
+	if act := describeOperations(nil); act != "ident()" {
+		t.Errorf("describeOperations(nil): exp=%q, act=%q", "ident()", act)
+	}
+}
+
+func TestCollectArgsDoesNotModifyInput(t *testing.T) {
+	ops := []string{"ident", "+", "ident"}
+	collectArgs(ops)
+	if strings.Join(ops, " ") != "ident + ident" {
+		t.Errorf("collectArgs modified its input: %v", ops)
+	}
+}
+
+func TestParseArgsReadsSizeLimits(t *testing.T) {
+	opts, err := parseArgs(strings.Fields("-i a.png b.png -maxpixels 1000 -maxdim 50 -o j"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.limits.maxPixels != 1000 || opts.limits.maxDim != 50 {
+		t.Errorf("parseArgs: unexpected limits %+v", opts.limits)
+	}
+	if strings.Join(opts.input, " ") != "a.png b.png" || opts.output != "j" {
+		t.Errorf("parseArgs: unexpected input %v or output %q", opts.input, opts.output)
+	}
+}
+
+func TestPreprocessArgsSkipsEmptyArgs(t *testing.T) {
+	args := []string{"", "-i", "a.png", "", "b.png", "-d", "scale", "s=2", ""}
+	exp := "-i a.png|b.png -d scale|s=2"
+	if act := strings.Join(preprocessArgs(args), " "); act != exp {
+		t.Errorf("preprocessArgs: exp=%q, act=%q", exp, act)
+	}
+	if len(preprocessArgs([]string{""})) != 0 {
+		t.Error("preprocessArgs should drop a lone empty arg")
+	}
+}
+
+func TestParseArgsReadsOutDir(t *testing.T) {
+	opts, err := parseArgs(strings.Fields("-i a.png -outdir ./out -o j"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.outDir != "./out" || opts.output != "j" {
+		t.Errorf("parseArgs: unexpected outdir %q or output %q", opts.outDir, opts.output)
+	}
+}
+
+func TestParseArgsReadsJobs(t *testing.T) {
+	opts, err := parseArgs(strings.Fields("-i a.png -j 3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.jobs != 3 {
+		t.Errorf("parseArgs: expected 3 jobs, got %d", opts.jobs)
+	}
+
+	opts, err = parseArgs(strings.Fields("-i a.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.jobs != runtime.NumCPU() {
+		t.Errorf("parseArgs: expected %d jobs by default, got %d", runtime.NumCPU(), opts.jobs)
+	}
+}
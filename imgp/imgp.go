@@ -11,11 +11,15 @@ import (
 	"fmt"
 	"github.com/smanoharan/go-img-proc/imgproc"
 	"image"
-	_ "image/gif"
+	"image/color"
+	"image/gif"
 	"image/jpeg"
 	"image/png"
 	"io"
 	"os"
+	"path/filepath"
+	"sync"
+	"time"
 )
 
 type imageEncoder func(io.Writer, image.Image) error
@@ -26,28 +30,112 @@ func toOutputEncoder(output string) (imageEncoder, error) {
 		return func(w io.Writer, m image.Image) error { return jpeg.Encode(w, m, nil) }, nil
 	case "p", "png":
 		return png.Encode, nil
-	// case "g","gif": 
-	//	return gif.Encode, nil // gif encoding is not supported in GO
+	case "g", "gif":
+		// gif is paletted: the colors are quantized to the 256 color Plan 9 palette,
+		// with Floyd-Steinberg dithering to reduce banding.
+		return func(w io.Writer, m image.Image) error { return gif.Encode(w, m, &gif.Options{NumColors: 256}) }, nil
 	}
 	return nil, errors.New("Unrecognized output format: " + output)
 }
 
-// read the inputFile, perform op and save as inputFile.outputFormat, using the supplied encoder.
-func processFile(inputFile, outputFormat string, encode imageEncoder, op ImageOp) error {
+// limits on the size of input images. Zero means unlimited.
+type sizeLimits struct {
+	maxPixels, maxDim int
+}
 
-	// open input file
-	input, err := os.Open(inputFile)
+// check the dimensions of the (encoded) image against the limits,
+// by reading only the image header, i.e. without decoding (and allocating) the whole image.
+// The reader is rewound to the start afterwards.
+func (limits sizeLimits) check(r io.ReadSeeker) error {
+	if limits.maxPixels <= 0 && limits.maxDim <= 0 {
+		return nil
+	}
+
+	config, _, err := image.DecodeConfig(bufio.NewReader(r))
 	if err != nil {
 		return err
 	}
-	defer input.Close()
+	if limits.maxDim > 0 && (config.Width > limits.maxDim || config.Height > limits.maxDim) {
+		return fmt.Errorf("image is %dx%d, which exceeds the maximum dimension of %d",
+			config.Width, config.Height, limits.maxDim)
+	}
+	if limits.maxPixels > 0 && int64(config.Width)*int64(config.Height) > int64(limits.maxPixels) {
+		return fmt.Errorf("image is %dx%d, which exceeds the maximum of %d pixels",
+			config.Width, config.Height, limits.maxPixels)
+	}
+
+	_, err = r.Seek(0, io.SeekStart)
+	return err
+}
 
-	// check output file is writable
-	output, err := os.Create(inputFile + "." + outputFormat)
+// open the inputFile, and check its size against the limits (without decoding it).
+// The caller must close the returned file.
+func openInput(inputFile string, limits sizeLimits) (*os.File, error) {
+	input, err := os.Open(inputFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := limits.check(input); err != nil {
+		input.Close()
+		return nil, errors.New(inputFile + ": " + err.Error())
+	}
+	return input, nil
+}
+
+// whether a decoded image can be encoded directly, giving the same output as
+// a round trip through a FloatImage: i.e. it is opaque, with 8-bit RGB colors.
+// (A FloatImage has no alpha, and outputs 8-bit colors).
+// Gray and paletted images always take the round trip: encoded directly, they would keep
+// their color model (e.g. a gray png, or a gif with the source palette rather than the Plan 9 one).
+func isDirectlyEncodable(img image.Image) bool {
+	switch m := img.(type) {
+	case *image.RGBA:
+		return m.Opaque()
+	case *image.NRGBA:
+		return m.Opaque()
+	}
+	return false
+}
+
+// the path of the output file for inputFile: inputFile.outputFormat, either alongside
+// the input (if outDir is empty), or in outDir (keeping only the base name of the input).
+func outputPath(inputFile, outputFormat, outDir string) string {
+	if outDir == "" {
+		return inputFile + "." + outputFormat
+	}
+	return filepath.Join(outDir, filepath.Base(inputFile)+"."+outputFormat)
+}
+
+// check that no two inputFiles would be written to the same output path:
+// e.g. a/x.png and b/x.png, when writing both into an outDir.
+// (Otherwise, one output would silently overwrite the other, or the two writes would race).
+func checkOutputPaths(inputFiles []string, outputFormat, outDir string) error {
+	inputOf := make(map[string]string) // the input file for each output path
+	for _, inputFile := range inputFiles {
+		path := filepath.Clean(outputPath(inputFile, outputFormat, outDir))
+		if other, found := inputOf[path]; found {
+			return fmt.Errorf("%s and %s would both be written to %s", other, inputFile, path)
+		}
+		inputOf[path] = inputFile
+	}
+	return nil
+}
+
+// read the inputFile, perform op and save as inputFile.outputFormat, using the supplied encoder.
+// The output is written into outDir (which is created if needed), or alongside the input if outDir is empty.
+// No output is written if the input cannot be decoded, or op fails.
+// Input images exceeding the size limits are rejected before being decoded.
+// A nil op means there are no operations (i.e. only format conversion): images which
+// don't need the float precision (see isDirectlyEncodable) are then encoded directly,
+// skipping the conversion to and from a FloatImage.
+func processFile(inputFile, outputFormat, outDir string, encode imageEncoder, op ImageOp, limits sizeLimits) error {
+
+	// open input file, checking its size before creating any output
+	input, err := openInput(inputFile, limits)
 	if err != nil {
 		return err
 	}
-	defer output.Close()
+	defer input.Close()
 
 	// decode into an image
 	image, _, err := image.Decode(bufio.NewReader(input))
@@ -55,10 +143,221 @@ func processFile(inputFile, outputFormat string, encode imageEncoder, op ImageOp
 		return err
 	}
 
+	// fast path: nothing to do but convert the format
+	if op == nil {
+		if isDirectlyEncodable(image) {
+			return writeOutput(inputFile, outputFormat, outDir, encode, image)
+		}
+		op = IdentityOp
+	}
+
 	// convert to floatImage, perform operations, and save
 	fImg := imgproc.ImageToFloatImage(image)
-	op(fImg)
-	return encode(output, fImg)
+	if err := op(fImg); err != nil {
+		return err
+	}
+	return writeOutput(inputFile, outputFormat, outDir, encode, fImg)
+}
+
+// create the output file for inputFile (creating outDir if needed), and encode img into it.
+// The output is only created once there is an image to write (so an input which fails to decode,
+// or whose operations fail, leaves no empty output behind), and is removed again if encoding fails.
+func writeOutput(inputFile, outputFormat, outDir string, encode imageEncoder, img image.Image) error {
+	if outDir != "" {
+		if err := os.MkdirAll(outDir, 0755); err != nil {
+			return err
+		}
+	}
+	path := outputPath(inputFile, outputFormat, outDir)
+	output, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := encode(output, img); err != nil {
+		output.Close()
+		os.Remove(path)
+		return err
+	}
+	return output.Close()
+}
+
+// the outcome of processing a single input file
+type fileResult struct {
+	err     error // nil if the file was processed successfully
+	elapsed time.Duration
+}
+
+// process each of the inputFiles as per processFile, with up to workers files processed concurrently.
+// A failure doesn't stop the remaining files from being processed: instead, the result of each file
+// (including its error, if any) is returned, in the same order as inputFiles.
+func processFiles(inputFiles []string, outputFormat, outDir string, encode imageEncoder, op ImageOp, limits sizeLimits, workers int) []fileResult {
+	if workers < 1 {
+		workers = 1
+	}
+
+	results := make([]fileResult, len(inputFiles))
+	next := make(chan int) // index of the next file to process
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				start := time.Now()
+				err := processFile(inputFiles[i], outputFormat, outDir, encode, op, limits)
+				results[i] = fileResult{err: err, elapsed: time.Since(start)}
+			}
+		}()
+	}
+
+	for i := range inputFiles {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return results
+}
+
+// metadata of an input image, as reported by -info
+type imageInfo struct {
+	format        string // as detected by image.Decode, e.g. "png"
+	width, height int
+	model         string // name of the color model
+	alpha         bool   // whether any pixel is (partially) transparent
+}
+
+func (info imageInfo) String() string {
+	return fmt.Sprintf("%s, %dx%d, %s, alpha=%t", info.format, info.width, info.height, info.model, info.alpha)
+}
+
+// name the color model of a decoded image
+func colorModelName(model color.Model) string {
+	// (checked first: a palette is a slice, so can't be compared against the other models)
+	if palette, ok := model.(color.Palette); ok {
+		return fmt.Sprintf("Paletted(%d colors)", len(palette))
+	}
+	switch model {
+	case color.RGBAModel:
+		return "RGBA"
+	case color.RGBA64Model:
+		return "RGBA64"
+	case color.NRGBAModel:
+		return "NRGBA"
+	case color.NRGBA64Model:
+		return "NRGBA64"
+	case color.AlphaModel:
+		return "Alpha"
+	case color.Alpha16Model:
+		return "Alpha16"
+	case color.GrayModel:
+		return "Gray"
+	case color.Gray16Model:
+		return "Gray16"
+	case color.CMYKModel:
+		return "CMYK"
+	case color.YCbCrModel:
+		return "YCbCr"
+	case color.NYCbCrAModel:
+		return "NYCbCrA"
+	}
+	return "unknown"
+}
+
+// read the metadata of the inputFile, by decoding it (but without applying any operations).
+// Input images exceeding the size limits are rejected before being decoded.
+func readImageInfo(inputFile string, limits sizeLimits) (info imageInfo, err error) {
+	input, err := openInput(inputFile, limits)
+	if err != nil {
+		return
+	}
+	defer input.Close()
+
+	img, format, err := image.Decode(bufio.NewReader(input))
+	if err != nil {
+		return
+	}
+
+	b := img.Bounds()
+	info = imageInfo{format: format, width: b.Dx(), height: b.Dy(), model: colorModelName(img.ColorModel())}
+	if opaque, ok := img.(interface{ Opaque() bool }); ok {
+		info.alpha = !opaque.Opaque()
+	}
+	return
+}
+
+// amplification of the differences in a -compare diff image, so that small differences are visible
+const COMPARE_GAIN = 8
+
+// the result of comparing two images, as reported by -compare
+type comparison struct {
+	diff       *imgproc.FloatImage // absolute difference, amplified by COMPARE_GAIN
+	psnr, ssim float64
+}
+
+// read and decode the inputFile (checking its size against the limits) into a FloatImage
+func readFloatImage(inputFile string, limits sizeLimits) (*imgproc.FloatImage, error) {
+	input, err := openInput(inputFile, limits)
+	if err != nil {
+		return nil, err
+	}
+	defer input.Close()
+
+	img, _, err := image.Decode(bufio.NewReader(input))
+	if err != nil {
+		return nil, err
+	}
+	return imgproc.ImageToFloatImage(img), nil
+}
+
+// compare the images in fileA and fileB, which must have the same dimensions.
+func compareFiles(fileA, fileB string, limits sizeLimits) (res comparison, err error) {
+	a, err := readFloatImage(fileA, limits)
+	if err != nil {
+		return
+	}
+	b, err := readFloatImage(fileB, limits)
+	if err != nil {
+		return
+	}
+
+	if res.diff, err = imgproc.AbsDiff(a, b, COMPARE_GAIN); err != nil {
+		return
+	}
+	if res.psnr, err = imgproc.PSNR(a, b); err != nil {
+		return
+	}
+	res.ssim, err = imgproc.SSIM(a, b)
+	return
+}
+
+// compare the two files, writing the PSNR and SSIM to report.
+// If diffPath names a file (i.e. it has an extension, such as diff.png), the diff image is
+// written to it, in the format given by its extension.
+func runCompare(files []string, diffPath string, limits sizeLimits, report io.Writer) error {
+	if len(files) != 2 {
+		return fmt.Errorf("-compare requires exactly two images, got %d", len(files))
+	}
+
+	res, err := compareFiles(files[0], files[1], limits)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(report, "PSNR: %.2f dB, SSIM: %.4f\n", res.psnr, res.ssim)
+
+	ext := filepath.Ext(diffPath)
+	if ext == "" {
+		return nil // no diff image requested
+	}
+	encode, err := toOutputEncoder(ext[1:])
+	if err != nil {
+		return err
+	}
+	output, err := os.Create(diffPath)
+	if err != nil {
+		return err
+	}
+	defer output.Close()
+	return encode(output, res.diff)
 }
 
 func printErrAndUsage(err error) {
@@ -89,13 +388,17 @@ func buildOperations(operations []string) (ImageOp,error) {
 
 	fullOp := IdentityOp 
 
-	for keyword, args := range collectArgs(operations) {
-		op, found := supported_ops[keyword]
-		if found {
-			fullOp = Compose(fullOp, op.Factory(args))
-		} else {
-			return nil, errors.New(keyword + " is not a supported operation")
+	// compose in the order given, i.e. left-to-right
+	for _, parsed := range collectArgs(operations) {
+		op, found := supported_ops[parsed.keyword]
+		if !found {
+			return nil, &OpError{Op: parsed.keyword, Err: ErrUnsupportedOp}
 		}
+		next, err := op.Factory(parsed.args)
+		if err != nil {
+			return nil, err
+		}
+		fullOp = Compose(fullOp, next)
 	}
 
 	return fullOp, nil 
@@ -104,20 +407,42 @@ func buildOperations(operations []string) (ImageOp,error) {
 func main() {
 
 	// parse arguments:
-	input, operations, help, output, err := parseArgs()
+	opts, err := parseArgs(os.Args[1:])
 	if err != nil {
 		printErrAndUsage(err)
 		return
 	}
 
 	// deal with help messages
-	if help != nil && len(help) > 0 {
-		fmt.Fprintln(os.Stderr, makeHelpMessage(help))
+	if len(opts.help) > 0 {
+		fmt.Fprintln(os.Stderr, makeHelpMessage(opts.help))
 		return // if help requested, ignore other params.
 	}
 
+	// if requested, compare two images instead of processing any
+	if len(opts.compare) > 0 {
+		if err := runCompare(opts.compare, opts.output, opts.limits, os.Stderr); err != nil {
+			printErrAndUsage(err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// expand and verify output format:
-	outputEncoder, err := toOutputEncoder(output)
+	outputEncoder, err := toOutputEncoder(opts.output)
+	if err != nil {
+		printErrAndUsage(err)
+		return
+	}
+
+	// load user presets, and expand the chosen preset (if any) into operations
+	if opts.presetsFile != "" {
+		if err := loadPresetsFile(opts.presetsFile); err != nil {
+			printErrAndUsage(err)
+			return
+		}
+	}
+	operations, err := expandPreset(opts.preset, opts.operations)
 	if err != nil {
 		printErrAndUsage(err)
 		return
@@ -129,22 +454,60 @@ func main() {
 		printErrAndUsage(err)
 		return
 	}
+	if len(collectArgs(operations)) == 0 {
+		op = nil // only format conversion: allow processFile's fast path
+	}
+
+	// if requested, describe the operations instead of running them
+	if opts.describe {
+		fmt.Println(describeOperations(operations))
+		return
+	}
 
 	// if input is empty, read from stdin
-	if input == nil || len(input) == 0 {
+	if len(opts.input) == 0 {
 		// read from stdin
 		line := ""
 		for n, err := fmt.Scan(&line); err == nil && n > 0; n, err = fmt.Scan(&line) {
-			input = append(input, line)
+			opts.input = append(opts.input, line)
 		}
 	}
 
-	// iterate over each file:
-	for _, inputFile := range input {
-		err = processFile(inputFile, output, outputEncoder, op)
-		if err != nil {
-			printErrAndUsage(err)
-			return
+	// if requested, report on each file instead of processing it
+	if opts.info {
+		for _, inputFile := range opts.input {
+			info, err := readImageInfo(inputFile, opts.limits)
+			if err != nil {
+				printErrAndUsage(err)
+				return
+			}
+			fmt.Println(inputFile+":", info)
 		}
+		return
+	}
+
+	// process the files, reporting every failure (rather than stopping at the first)
+	if err := checkOutputPaths(opts.input, opts.output, opts.outDir); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	results := processFiles(opts.input, opts.output, opts.outDir, outputEncoder, op, opts.limits, opts.jobs)
+	failed := 0
+	for i, res := range results {
+		if res.err != nil {
+			fmt.Fprintln(os.Stderr, opts.input[i]+":", res.err)
+			failed++
+		}
+	}
+	if opts.manifest != "" {
+		entries := buildManifest(opts.input, results, opts.output, opts.outDir, describeOperations(operations))
+		if err := writeManifest(opts.manifest, entries); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d files failed\n", failed, len(opts.input))
+		os.Exit(1)
 	}
 }
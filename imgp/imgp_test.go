@@ -0,0 +1,465 @@
+// Test file for imgp.go
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/smanoharan/go-img-proc/imgproc"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// add a pixel map to the supported ops, for the duration of a test.
+// The returned function removes it again.
+func registerTestOp(keyword string, fn func(float32) float32) func() {
+	supported_ops[keyword] = supportedOp{
+		Desc:  "test operation",
+		Usage: "test operation",
+		Factory: func(args []string) (ImageOp, error) {
+			return func(img *imgproc.FloatImage) error {
+				for i := 0; i < 3; i++ {
+					for j, v := range img.Ip[i] {
+						img.Ip[i][j] = fn(v)
+					}
+				}
+				return nil
+			}, nil
+		},
+	}
+	return func() { delete(supported_ops, keyword) }
+}
+
+// three operations which do not commute with each other.
+func testBrighten(v float32) float32 { return v + 10000 }
+func testInvert(v float32) float32   { return 65535 - v }
+func testThreshold(v float32) float32 {
+	if v >= 30000 {
+		return 65535
+	}
+	return 0
+}
+
+func TestBuildOperationsComposesLeftToRight(t *testing.T) {
+	defer registerTestOp("tbright", testBrighten)()
+	defer registerTestOp("tinvert", testInvert)()
+	defer registerTestOp("tthresh", testThreshold)()
+
+	// intensities chosen so that each ordering of the three ops gives a different result
+	input := []float32{0, 25000, 50000}
+	expected := make([]float32, len(input))
+	for i, v := range input {
+		expected[i] = testInvert(testThreshold(testBrighten(v)))
+	}
+
+	// map iteration order is randomised, so repeat to catch any nondeterminism
+	for run := 0; run < 100; run++ {
+		op, err := buildOperations([]string{"tbright", "+", "tthresh", "+", "tinvert"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		img := imgproc.NewFloatImage(len(input), 1)
+		for i := 0; i < 3; i++ {
+			copy(img.Ip[i], input)
+		}
+		op(img)
+
+		for i := range input {
+			if img.Ip[0][i] != expected[i] {
+				t.Fatalf("run %d, pixel %d: exp=%f, act=%f", run, i, expected[i], img.Ip[0][i])
+			}
+		}
+	}
+}
+
+func TestBuildOperationsRejectsUnknownOperation(t *testing.T) {
+	_, err := buildOperations([]string{"ident", "+", "nosuchop"})
+	if err == nil {
+		t.Error("buildOperations should reject an unsupported operation")
+	}
+}
+
+func TestCollectArgsKeepsOrder(t *testing.T) {
+	parsed := collectArgs([]string{"b", "x=1", "+", "a", "+", "c", "y=2", "z=3"})
+	expKeywords := []string{"b", "a", "c"}
+	expArgs := [][]string{{"-b", "-x=1"}, {"-a"}, {"-c", "-y=2", "-z=3"}}
+
+	if len(parsed) != len(expKeywords) {
+		t.Fatalf("collectArgs: exp %d operations, act %d", len(expKeywords), len(parsed))
+	}
+	for i := range parsed {
+		if parsed[i].keyword != expKeywords[i] {
+			t.Errorf("collectArgs[%d].keyword: exp=%s, act=%s", i, expKeywords[i], parsed[i].keyword)
+		}
+		if fmt.Sprint(parsed[i].args) != fmt.Sprint(expArgs[i]) {
+			t.Errorf("collectArgs[%d].args: exp=%v, act=%v", i, expArgs[i], parsed[i].args)
+		}
+	}
+}
+
+// write a (black) png of the given size into dir, returning its path
+func writeTestPng(t *testing.T, dir, name string, width, height int) string {
+	path := filepath.Join(dir, name)
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if err := png.Encode(file, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestProcessFileRejectsImagesOverPixelBudget(t *testing.T) {
+	dir := t.TempDir()
+	path := writeTestPng(t, dir, "big.png", 200, 100)
+
+	err := processFile(path, "png", "", png.Encode, IdentityOp, sizeLimits{maxPixels: 10000})
+	if err == nil {
+		t.Fatal("processFile should reject an image exceeding the pixel budget")
+	}
+
+	// rejected before any output was created
+	if _, statErr := os.Stat(path + ".png"); !os.IsNotExist(statErr) {
+		t.Error("processFile should not create output for a rejected image")
+	}
+}
+
+func TestProcessFileRejectsImagesOverMaxDimension(t *testing.T) {
+	dir := t.TempDir()
+	path := writeTestPng(t, dir, "wide.png", 300, 10)
+	if err := processFile(path, "png", "", png.Encode, IdentityOp, sizeLimits{maxDim: 256}); err == nil {
+		t.Error("processFile should reject an image exceeding the maximum dimension")
+	}
+}
+
+func TestProcessFileAcceptsImagesWithinLimits(t *testing.T) {
+	dir := t.TempDir()
+	path := writeTestPng(t, dir, "small.png", 20, 10)
+	if err := processFile(path, "png", "", png.Encode, IdentityOp, sizeLimits{maxPixels: 200, maxDim: 20}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".png"); err != nil {
+		t.Error("processFile should write the output:", err)
+	}
+}
+
+func TestReadImageInfoOfPng(t *testing.T) {
+	dir := t.TempDir()
+	path := writeTestPng(t, dir, "info.png", 20, 10)
+
+	info, err := readImageInfo(path, sizeLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the test png is fully transparent, so is stored (and decoded) with non-premultiplied alpha
+	exp := imageInfo{format: "png", width: 20, height: 10, model: "NRGBA", alpha: true}
+	if info != exp {
+		t.Errorf("readImageInfo: exp=%v, act=%v", exp, info)
+	}
+
+	// nothing is written
+	if _, statErr := os.Stat(path + ".png"); !os.IsNotExist(statErr) {
+		t.Error("readImageInfo should not create any output")
+	}
+}
+
+func TestReadImageInfoRespectsLimits(t *testing.T) {
+	dir := t.TempDir()
+	path := writeTestPng(t, dir, "big.png", 200, 100)
+	if _, err := readImageInfo(path, sizeLimits{maxDim: 100}); err == nil {
+		t.Error("readImageInfo should reject an image exceeding the maximum dimension")
+	}
+}
+
+func TestGifOutputRoundTrips(t *testing.T) {
+	encode, err := toOutputEncoder("gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a synthetic RGB image, with more colors than a gif can hold
+	img := imgproc.NewFloatImage(32, 32)
+	for i := range img.Ip[0] {
+		img.Ip[0][i], img.Ip[1][i], img.Ip[2][i] = float32(i*64), float32((i*37)%65536), 30000
+	}
+
+	var buf bytes.Buffer
+	if err := encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	decoded, format, err := image.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "gif" || decoded.Bounds().Dx() != 32 || decoded.Bounds().Dy() != 32 {
+		t.Errorf("gif: unexpected %s image of size %v", format, decoded.Bounds())
+	}
+}
+
+// write the image as a png into dir, returning its path
+func writePng(t *testing.T, dir, name string, img image.Image) string {
+	path := filepath.Join(dir, name)
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := png.Encode(file, img); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// decode the image in the named file
+func readImage(t *testing.T, path string) image.Image {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	img, _, err := image.Decode(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+func TestFormatConversionFastPathMatchesFloatPath(t *testing.T) {
+	opaque := image.NewRGBA(image.Rect(0, 0, 5, 4))
+	translucent := image.NewNRGBA(image.Rect(0, 0, 5, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 5; x++ {
+			opaque.Set(x, y, color.RGBA{uint8(50 * x), uint8(60 * y), uint8(x * y), 255})
+			translucent.Set(x, y, color.NRGBA{uint8(50 * x), uint8(60 * y), 200, uint8(60 * y)})
+		}
+	}
+	// an opaque palette, of colors outside the Plan 9 palette used by the gif encoder
+	palette := color.Palette{color.RGBA{13, 77, 201, 255}, color.RGBA{250, 3, 99, 255}, color.RGBA{121, 187, 7, 255}}
+	paletted := image.NewPaletted(image.Rect(0, 0, 5, 4), palette)
+	for i := range paletted.Pix {
+		paletted.Pix[i] = uint8(i % len(palette))
+	}
+	if !isDirectlyEncodable(opaque) || isDirectlyEncodable(translucent) || isDirectlyEncodable(paletted) {
+		t.Error("only the opaque RGBA image should take the fast path")
+	}
+
+	dir := t.TempDir()
+	for _, c := range []struct {
+		src    image.Image
+		format string
+	}{{opaque, "jpg"}, {translucent, "jpg"}, {paletted, "gif"}} {
+		fast := writePng(t, dir, "fast.png", c.src)
+		slow := writePng(t, dir, "slow.png", c.src)
+		if err := processFile(fast, c.format, "", mustEncoder(t, c.format), nil, sizeLimits{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := processFile(slow, c.format, "", mustEncoder(t, c.format), IdentityOp, sizeLimits{}); err != nil {
+			t.Fatal(err)
+		}
+
+		fastImg, slowImg := readImage(t, fast+"."+c.format), readImage(t, slow+"."+c.format)
+		for y := 0; y < 4; y++ {
+			for x := 0; x < 5; x++ {
+				if fastImg.At(x, y) != slowImg.At(x, y) {
+					t.Fatalf("%T -> %s: pixel (%d,%d) differs: fast=%v, float=%v", c.src, c.format, x, y, fastImg.At(x, y), slowImg.At(x, y))
+				}
+			}
+		}
+	}
+}
+
+// look up an output encoder, failing the test if it is not supported
+func mustEncoder(t *testing.T, output string) imageEncoder {
+	encode, err := toOutputEncoder(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encode
+}
+
+func TestProcessFileWritesAlongsideInputByDefault(t *testing.T) {
+	dir := t.TempDir()
+	path := writeTestPng(t, dir, "default.png", 4, 4)
+	if err := processFile(path, "jpg", "", mustEncoder(t, "jpg"), IdentityOp, sizeLimits{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "default.png.jpg")); err != nil {
+		t.Error("processFile should write the output alongside the input:", err)
+	}
+}
+
+func TestProcessFileWritesIntoOutDir(t *testing.T) {
+	dir := t.TempDir()
+	path := writeTestPng(t, dir, "redirected.png", 4, 4)
+	outDir := filepath.Join(dir, "out", "nested") // doesn't exist yet
+
+	if err := processFile(path, "png", outDir, png.Encode, IdentityOp, sizeLimits{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "redirected.png.png")); err != nil {
+		t.Error("processFile should write the output into the output directory:", err)
+	}
+	if _, err := os.Stat(path + ".png"); !os.IsNotExist(err) {
+		t.Error("processFile should not write the output alongside the input")
+	}
+}
+
+func TestProcessFileOfFailingCropLeavesNoOutput(t *testing.T) {
+	dir := t.TempDir()
+	path := writeTestPng(t, dir, "cropped.png", 4, 4)
+	op, err := buildOperations(strings.Fields("crop x=10 y=0 w=2 h=2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := processFile(path, "png", "", png.Encode, op, sizeLimits{}); err == nil {
+		t.Fatal("processFile should fail when the crop lies outside the image")
+	}
+	if _, err := os.Stat(path + ".png"); !os.IsNotExist(err) {
+		t.Error("processFile should not leave an output for a failed crop")
+	}
+}
+
+func TestProcessFileOfUndecodableInputLeavesOutDirEmpty(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "broken.png")
+	if err := os.WriteFile(path, []byte("not an image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outDir := filepath.Join(dir, "out")
+
+	if err := processFile(path, "png", outDir, png.Encode, IdentityOp, sizeLimits{}); err == nil {
+		t.Fatal("processFile should fail to decode the input")
+	}
+	if _, err := os.Stat(outDir); !os.IsNotExist(err) {
+		t.Error("processFile should not create the output directory (or any output) for a failed input")
+	}
+}
+
+func TestCheckOutputPathsRejectsCollisionsInOutDir(t *testing.T) {
+	inputs := []string{filepath.Join("a", "x.png"), filepath.Join("b", "y.png"), filepath.Join("b", "x.png")}
+	err := checkOutputPaths(inputs, "png", "out")
+	if err == nil || !strings.Contains(err.Error(), inputs[0]) || !strings.Contains(err.Error(), inputs[2]) {
+		t.Errorf("checkOutputPaths should report the colliding inputs, got: %v", err)
+	}
+
+	// alongside the inputs, the same base names don't collide
+	if err := checkOutputPaths(inputs, "png", ""); err != nil {
+		t.Error("checkOutputPaths should accept distinct outputs:", err)
+	}
+	if err := checkOutputPaths(inputs[:2], "png", "out"); err != nil {
+		t.Error("checkOutputPaths should accept distinct base names:", err)
+	}
+}
+
+// write a distinct (opaque) png for each name into dir, returning their paths
+func writeDistinctPngs(t *testing.T, dir string, names ...string) []string {
+	paths := make([]string, len(names))
+	for n, name := range names {
+		img := image.NewRGBA(image.Rect(0, 0, 6, 5))
+		for y := 0; y < 5; y++ {
+			for x := 0; x < 6; x++ {
+				img.Set(x, y, color.RGBA{uint8(40 * x), uint8(50 * y), uint8(30 * n), 255})
+			}
+		}
+		paths[n] = writePng(t, dir, name, img)
+	}
+	return paths
+}
+
+func TestProcessFilesConcurrentlyMatchesSerial(t *testing.T) {
+	dir := t.TempDir()
+	inputs := writeDistinctPngs(t, dir, "a.png", "b.png", "c.png", "d.png", "e.png")
+	op := func(img *imgproc.FloatImage) error {
+		img.Brightness(5000)
+		return nil
+	}
+	serialDir, parallelDir := filepath.Join(dir, "serial"), filepath.Join(dir, "parallel")
+
+	for _, input := range inputs {
+		if err := processFile(input, "png", serialDir, png.Encode, op, sizeLimits{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, res := range processFiles(inputs, "png", parallelDir, png.Encode, op, sizeLimits{}, 3) {
+		if res.err != nil {
+			t.Fatalf("processFiles[%d]: %v", i, res.err)
+		}
+	}
+
+	for _, input := range inputs {
+		name := filepath.Base(input) + ".png"
+		serial, err := os.ReadFile(filepath.Join(serialDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		parallel, err := os.ReadFile(filepath.Join(parallelDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(serial, parallel) {
+			t.Errorf("processFiles: output of %s differs from the serial output", name)
+		}
+	}
+}
+
+func TestProcessFilesContinuesPastFailures(t *testing.T) {
+	dir := t.TempDir()
+	inputs := writeDistinctPngs(t, dir, "first.png", "last.png")
+	inputs = []string{inputs[0], filepath.Join(dir, "missing.png"), inputs[1]}
+
+	results := processFiles(inputs, "png", "", png.Encode, IdentityOp, sizeLimits{}, 2)
+	if results[0].err != nil || results[1].err == nil || results[2].err != nil {
+		t.Fatalf("processFiles: only the missing file should fail, got %v", results)
+	}
+	for _, input := range []string{inputs[0], inputs[2]} {
+		if _, err := os.Stat(input + ".png"); err != nil {
+			t.Error("processFiles should still process the other files:", err)
+		}
+	}
+}
+
+func TestCompareIdenticalImagesGivesBlackDiff(t *testing.T) {
+	dir := t.TempDir()
+	paths := writeDistinctPngs(t, dir, "a.png")
+	diffPath := filepath.Join(dir, "diff.png")
+
+	var report bytes.Buffer
+	if err := runCompare([]string{paths[0], paths[0]}, diffPath, sizeLimits{}, &report); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(report.Bytes(), []byte("PSNR: +Inf dB")) {
+		t.Errorf("compare should report an infinite PSNR, got %q", report.String())
+	}
+
+	diff := readImage(t, diffPath)
+	b := diff.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if r, g, bl, _ := diff.At(x, y).RGBA(); r != 0 || g != 0 || bl != 0 {
+				t.Fatalf("diff pixel (%d,%d) should be black, got %v", x, y, diff.At(x, y))
+			}
+		}
+	}
+}
+
+func TestCompareRejectsMismatchedSizes(t *testing.T) {
+	dir := t.TempDir()
+	a := writeTestPng(t, dir, "a.png", 4, 4)
+	b := writeTestPng(t, dir, "b.png", 4, 5)
+	if _, err := compareFiles(a, b, sizeLimits{}); err == nil {
+		t.Error("compare should reject images of different sizes")
+	}
+	if err := runCompare([]string{a}, "", sizeLimits{}, &bytes.Buffer{}); err == nil {
+		t.Error("compare should require two images")
+	}
+}
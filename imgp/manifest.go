@@ -0,0 +1,68 @@
+// Writes the -manifest: a JSON record of each file processed in a batch.
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"os"
+)
+
+// the record of a single processed file, as written to the manifest
+type manifestEntry struct {
+	Input      string  `json:"input"`
+	Output     string  `json:"output"`
+	Width      int     `json:"width,omitempty"` // of the output image (omitted if it failed)
+	Height     int     `json:"height,omitempty"`
+	Operations string  `json:"operations"` // as per describeOperations
+	Success    bool    `json:"success"`
+	Error      string  `json:"error,omitempty"`
+	Seconds    float64 `json:"seconds"` // time taken to process the file
+}
+
+// read the dimensions of an image file, without decoding the whole image
+func readDimensions(path string) (width, height int, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	config, _, err := image.DecodeConfig(file)
+	return config.Width, config.Height, err
+}
+
+// build the manifest entries for the inputFiles, from their results (as returned by processFiles).
+// operations is the description of the operations applied to each file.
+func buildManifest(inputFiles []string, results []fileResult, outputFormat, outDir, operations string) []manifestEntry {
+	entries := make([]manifestEntry, len(inputFiles))
+	for i, inputFile := range inputFiles {
+		entry := manifestEntry{
+			Input:      inputFile,
+			Output:     outputPath(inputFile, outputFormat, outDir),
+			Operations: operations,
+			Seconds:    results[i].elapsed.Seconds(),
+		}
+
+		err := results[i].err
+		if err == nil {
+			entry.Width, entry.Height, err = readDimensions(entry.Output)
+		}
+		if err != nil {
+			entry.Width, entry.Height = 0, 0
+			entry.Error = err.Error()
+		} else {
+			entry.Success = true
+		}
+		entries[i] = entry
+	}
+	return entries
+}
+
+// write the manifest entries to path, as a JSON array
+func writeManifest(path string, entries []manifestEntry) error {
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
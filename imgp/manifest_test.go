@@ -0,0 +1,64 @@
+// Test file for manifest.go
+
+package main
+
+import (
+	"encoding/json"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestManifestRecordsEachFile(t *testing.T) {
+	dir := t.TempDir()
+	inputs := writeDistinctPngs(t, dir, "a.png", "b.png")
+	inputs = append(inputs, filepath.Join(dir, "missing.png"))
+	outDir := filepath.Join(dir, "out")
+
+	operations := strings.Fields("scale s=2")
+	op, err := buildOperations(operations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := processFiles(inputs, "png", outDir, png.Encode, op, sizeLimits{}, 2)
+
+	manifestPath := filepath.Join(dir, "manifest.json")
+	if err := writeManifest(manifestPath, buildManifest(inputs, results, "png", outDir, describeOperations(operations))); err != nil {
+		t.Fatal(err)
+	}
+
+	// read back the raw JSON, to check the field names
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []map[string]interface{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("manifest: expected 3 entries, got %d", len(entries))
+	}
+
+	for i, entry := range entries[:2] {
+		if entry["input"] != inputs[i] || entry["output"] != filepath.Join(outDir, filepath.Base(inputs[i])+".png") {
+			t.Errorf("manifest[%d]: unexpected paths: %v", i, entry)
+		}
+		if entry["width"] != 12.0 || entry["height"] != 10.0 {
+			t.Errorf("manifest[%d]: expected the scaled size 12x10, got %v", i, entry)
+		}
+		if entry["operations"] != "scale(s=2.0)" || entry["success"] != true || entry["error"] != nil {
+			t.Errorf("manifest[%d]: unexpected result: %v", i, entry)
+		}
+		if _, ok := entry["seconds"].(float64); !ok {
+			t.Errorf("manifest[%d]: missing the time taken: %v", i, entry)
+		}
+	}
+
+	failed := entries[2]
+	if failed["success"] != false || failed["error"] == nil || failed["width"] != nil {
+		t.Errorf("manifest: the missing file should be recorded as failed: %v", failed)
+	}
+}
@@ -2,37 +2,381 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"fmt"
 	"github.com/smanoharan/go-img-proc/imgproc"
-	)
+	"math"
+	"sync"
+)
 
 // function signature for each operation: mutate the input image. 
-type ImageOp func(*imgproc.FloatImage)
+// Operations which change the dimensions of the image replace the image in place (i.e. *img = *res).
+// Returns an error (an *OpError) if the operation cannot be applied to this image: e.g. a crop
+// rectangle which lies outside it. (Invalid arguments are rejected by the factory, before any image is read).
+type ImageOp func(*imgproc.FloatImage) error
 
 // do nothing
-func IdentityOp(img *imgproc.FloatImage) {
+func IdentityOp(img *imgproc.FloatImage) error {
+	return nil
 }
 
 // Compose two Image operations into a single operation.
 // I.e. if h := Compose(f,g), then h(img) is equivalent to g(f(img))
+// As operations which change the dimensions (e.g. scale, crop) replace the image in place,
+// g sees the image as resized by f. If f fails, g is not applied.
 func Compose(op1, op2 ImageOp) ImageOp {
-	return func(img *imgproc.FloatImage) {
+	return func(img *imgproc.FloatImage) error {
 		// perform op1 then op2
-		op1(img)
-		op2(img)
+		if err := op1(img); err != nil {
+			return err
+		}
+		return op2(img)
 	}
 }
 
 // for each op, we need:
 //	keyword (i.e. name)
 //	1-line description and a full usage message
-//  argument interpreter : takes []string and returns ImageOp
+//  argument interpreter : takes []string and returns ImageOp, or an error if the args are invalid
 type supportedOp struct {
 	Desc, Usage string
-	Factory func(args []string) ImageOp
+	Factory func(args []string) (ImageOp, error)
 }
 
-func IdentityFactory(args []string) ImageOp {
-	return IdentityOp
+func IdentityFactory(args []string) (ImageOp, error) {
+	return IdentityOp, nil
+}
+
+// the cause of an OpError for an operation keyword which is not in supported_ops.
+var ErrUnsupportedOp = errors.New("not a supported operation")
+
+// An OpError records an invalid operation on the command line: the operation keyword,
+// the offending parameter (empty if the error is not specific to one parameter), and the cause.
+type OpError struct {
+	Op    string
+	Param string
+	Err   error
+}
+
+func (e *OpError) Error() string {
+	if e.Param == "" {
+		return e.Op + ": " + e.Err.Error()
+	}
+	return e.Op + ": " + e.Param + ": " + e.Err.Error()
+}
+
+func (e *OpError) Unwrap() error { return e.Err }
+
+// construct an OpError, with a formatted cause
+func newOpError(op, param, format string, a ...interface{}) *OpError {
+	return &OpError{Op: op, Param: param, Err: fmt.Errorf(format, a...)}
+}
+
+// parse the args of an operation (in the form [-keyword -par1=v1 ...]) into the flags
+// defined on the FlagSet by defineFlags.
+func parseOpArgs(args []string, defineFlags func(flags *flag.FlagSet)) error {
+	keyword := args[0][1:] // strip the dash
+	flags := flag.NewFlagSet(keyword, flag.ContinueOnError)
+	flags.SetOutput(&EmptyWriter{}) // suppress output. We have custom error printing.
+	defineFlags(flags)
+
+	if err := flags.Parse(args[1:]); err != nil {
+		return &OpError{Op: keyword, Err: err}
+	}
+	if flags.NArg() > 0 {
+		return newOpError(keyword, flags.Arg(0), "unexpected argument")
+	}
+	if parsedOpArgs != nil {
+		parsedOpArgs(flags)
+	}
+	return nil
+}
+
+// if set, parseOpArgs passes it each successfully parsed FlagSet, holding the final (given or default)
+// values of the operation's parameters. Only set by describeOperations, while holding describing.
+var (
+	describing   sync.Mutex
+	parsedOpArgs func(flags *flag.FlagSet)
+)
+
+// the edge modes selectable by the e=<mode> parameter of the filter operations
+var edgeModes = map[string]imgproc.EdgeMode{
+	"clamp":   imgproc.EdgeClamp,
+	"wrap":    imgproc.EdgeWrap,
+	"reflect": imgproc.EdgeReflect,
+	"zero":    imgproc.EdgeZero,
+}
+
+// look up the edge mode named by the e=<mode> parameter of the op
+func parseEdgeMode(op, name string) (imgproc.EdgeMode, error) {
+	mode, ok := edgeModes[name]
+	if !ok {
+		return 0, newOpError(op, "e", "unknown edge mode %q: expected e=clamp, e=wrap, e=reflect or e=zero", name)
+	}
+	return mode, nil
+}
+
+// scale s=<factor> | w=<width> h=<height> | l=<long edge> [u=1]
+func ScaleFactory(args []string) (ImageOp, error) {
+	var scale float64
+	var width, height, longEdge int
+	var upscale bool
+	err := parseOpArgs(args, func(flags *flag.FlagSet) {
+		flags.Float64Var(&scale, "s", 0, "")
+		flags.IntVar(&width, "w", 0, "")
+		flags.IntVar(&height, "h", 0, "")
+		flags.IntVar(&longEdge, "l", 0, "")
+		flags.BoolVar(&upscale, "u", false, "")
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case scale < 0:
+		return nil, newOpError("scale", "s", "must be positive")
+	case width < 0:
+		return nil, newOpError("scale", "w", "must be positive")
+	case height < 0:
+		return nil, newOpError("scale", "h", "must be positive")
+	case longEdge < 0:
+		return nil, newOpError("scale", "l", "must be positive")
+	case scale > 0 && (width > 0 || height > 0):
+		return nil, newOpError("scale", "s", "specify either s, or w and/or h, but not both")
+	case longEdge > 0 && (scale > 0 || width > 0 || height > 0):
+		return nil, newOpError("scale", "l", "specify only one of l, s, or w and/or h")
+	case upscale && longEdge == 0:
+		return nil, newOpError("scale", "u", "only applies together with l")
+	case scale == 0 && width == 0 && height == 0 && longEdge == 0:
+		return nil, newOpError("scale", "", "one of s, w, h or l is required")
+	}
+
+	return func(img *imgproc.FloatImage) error {
+		w, h, s := width, height, scale
+		if longEdge > 0 { // scale the longer of the two dimensions to longEdge
+			s = float64(longEdge) / math.Max(float64(img.Width), float64(img.Height))
+			if s > 1 && !upscale { // already within the long edge: leave it alone
+				return nil
+			}
+		}
+		if s > 0 {
+			w, h = scaleDim(img.Width, s), scaleDim(img.Height, s)
+		} else if w == 0 { // preserve the aspect ratio
+			w = scaleDim(img.Width, float64(h)/float64(img.Height))
+		} else if h == 0 {
+			h = scaleDim(img.Height, float64(w)/float64(img.Width))
+		}
+		*img = *img.ResizeBilinear(w, h, imgproc.CenterAligned)
+		return nil
+	}, nil
+}
+
+// scale a dimension, rounding to the nearest pixel (but keeping at least one pixel).
+func scaleDim(dim int, scale float64) int {
+	return int(math.Max(1, math.Floor(float64(dim)*scale+0.5)))
+}
+
+// flip o=vert|horiz
+func FlipFactory(args []string) (ImageOp, error) {
+	var orientation string
+	err := parseOpArgs(args, func(flags *flag.FlagSet) {
+		flags.StringVar(&orientation, "o", "", "")
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch orientation {
+	case "vert":
+		return func(img *imgproc.FloatImage) error {
+			img.FlipVertical()
+			return nil
+		}, nil
+	case "horiz":
+		return func(img *imgproc.FloatImage) error {
+			img.FlipHorizontal()
+			return nil
+		}, nil
+	case "":
+		return nil, newOpError("flip", "o", "an orientation is required: o=vert or o=horiz")
+	}
+	return nil, newOpError("flip", "o", "unknown orientation %q: expected o=vert or o=horiz", orientation)
+}
+
+// blur r=<radius> v=<variance> e=(clamp|wrap|reflect|zero)
+func BlurFactory(args []string) (ImageOp, error) {
+	var radius int
+	var variance float64
+	var edge string
+	err := parseOpArgs(args, func(flags *flag.FlagSet) {
+		flags.IntVar(&radius, "r", 1, "")
+		flags.Float64Var(&variance, "v", 1.0, "")
+		flags.StringVar(&edge, "e", "clamp", "")
+	})
+	if err != nil {
+		return nil, err
+	}
+	if radius < 0 {
+		return nil, newOpError("blur", "r", "must not be negative")
+	}
+	if variance <= 0 {
+		return nil, newOpError("blur", "v", "must be positive")
+	}
+	mode, err := parseEdgeMode("blur", edge)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(img *imgproc.FloatImage) error {
+		*img = *img.GaussianBlurSeparableEdge(radius, variance, mode)
+		return nil
+	}, nil
+}
+
+// sharpen r=<radius> a=<amount> t=<threshold> m=(unsharp|laplace) e=(clamp|wrap|reflect|zero)
+func SharpenFactory(args []string) (ImageOp, error) {
+	var radius int
+	var amount, threshold float64
+	var mode, edge string
+	err := parseOpArgs(args, func(flags *flag.FlagSet) {
+		flags.IntVar(&radius, "r", 1, "")
+		flags.Float64Var(&amount, "a", 1.0, "")
+		flags.Float64Var(&threshold, "t", 0, "")
+		flags.StringVar(&mode, "m", "unsharp", "")
+		flags.StringVar(&edge, "e", "clamp", "")
+	})
+	if err != nil {
+		return nil, err
+	}
+	edgeMode, err := parseEdgeMode("sharpen", edge)
+	if err != nil {
+		return nil, err
+	}
+
+	switch mode {
+	case "unsharp":
+		switch {
+		case radius < 0:
+			return nil, newOpError("sharpen", "r", "must not be negative")
+		case amount <= 0:
+			return nil, newOpError("sharpen", "a", "must be positive")
+		case threshold < 0:
+			return nil, newOpError("sharpen", "t", "must not be negative")
+		}
+		return func(img *imgproc.FloatImage) error {
+			img.UnsharpEdge(radius, amount, threshold, edgeMode)
+			return nil
+		}, nil
+	case "laplace":
+		return func(img *imgproc.FloatImage) error {
+			img.SharpenLaplaceEdge(edgeMode)
+			return nil
+		}, nil
+	}
+	return nil, newOpError("sharpen", "m", "unknown mode %q: expected m=unsharp or m=laplace", mode)
+}
+
+// equalize mode=(luma|rgb)
+func EqualizeFactory(args []string) (ImageOp, error) {
+	var mode string
+	err := parseOpArgs(args, func(flags *flag.FlagSet) {
+		flags.StringVar(&mode, "mode", "luma", "")
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch mode {
+	case "luma":
+		return func(img *imgproc.FloatImage) error {
+			img.EqualizeLuma()
+			return nil
+		}, nil
+	case "rgb":
+		return func(img *imgproc.FloatImage) error {
+			img.EqualizeHistogram()
+			return nil
+		}, nil
+	}
+	return nil, newOpError("equalize", "mode", "unknown mode %q: expected mode=luma or mode=rgb", mode)
+}
+
+// denoise (no arguments): the filter strength is chosen from the estimated noise level
+func DenoiseFactory(args []string) (ImageOp, error) {
+	if err := parseOpArgs(args, func(flags *flag.FlagSet) {}); err != nil {
+		return nil, err
+	}
+
+	return func(img *imgproc.FloatImage) error {
+		noise := img.EstimateNoise()
+		if noise <= 0 {
+			return nil // nothing to remove
+		}
+		// differences within a few standard deviations of the noise are smoothed out;
+		// larger differences (i.e. real edges) are kept.
+		*img = *img.BilateralFilter(1.5, 2.5*noise)
+		return nil
+	}, nil
+}
+
+// gray (no arguments)
+func GrayFactory(args []string) (ImageOp, error) {
+	if err := parseOpArgs(args, func(flags *flag.FlagSet) {}); err != nil {
+		return nil, err
+	}
+	return func(img *imgproc.FloatImage) error {
+		img.Grayscale()
+		return nil
+	}, nil
+}
+
+// crop x=<int> y=<int> w=<int> h=<int>
+func CropFactory(args []string) (ImageOp, error) {
+	var x, y, width, height int
+	err := parseOpArgs(args, func(flags *flag.FlagSet) {
+		flags.IntVar(&x, "x", 0, "")
+		flags.IntVar(&y, "y", 0, "")
+		flags.IntVar(&width, "w", 0, "")
+		flags.IntVar(&height, "h", 0, "")
+	})
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case x < 0:
+		return nil, newOpError("crop", "x", "must not be negative")
+	case y < 0:
+		return nil, newOpError("crop", "y", "must not be negative")
+	case width <= 0:
+		return nil, newOpError("crop", "w", "must be positive")
+	case height <= 0:
+		return nil, newOpError("crop", "h", "must be positive")
+	}
+
+	return func(img *imgproc.FloatImage) error {
+		// the image size is only known now: reject a rectangle which selects nothing,
+		// and clip the rest to the image
+		if x >= img.Width {
+			return newOpError("crop", "x", "the rectangle lies outside the %dx%d image (x=%d)", img.Width, img.Height, x)
+		}
+		if y >= img.Height {
+			return newOpError("crop", "y", "the rectangle lies outside the %dx%d image (y=%d)", img.Width, img.Height, y)
+		}
+		x1, y1 := x+width, y+height
+		if x1 > img.Width {
+			x1 = img.Width
+		}
+		if y1 > img.Height {
+			y1 = img.Height
+		}
+		res, err := img.Crop(x, y, x1, y1)
+		if err != nil {
+			return &OpError{Op: "crop", Err: err}
+		}
+		*img = *res
+		return nil
+	}, nil
 }
 
 var supported_ops map[string]supportedOp = map[string]supportedOp {
@@ -41,4 +385,71 @@ var supported_ops map[string]supportedOp = map[string]supportedOp {
 		Usage: "Identity transform: does not modify the image",
 		Factory: IdentityFactory,
 	}, 
+	"scale": {
+		Desc: "s=<factor> | w=<width> h=<height> | l=<long edge> [u=1] -- Resize the image",
+		Usage: "Resize the image, using bilinear interpolation.\n" +
+			"\t\t s=<factor> scales both dimensions by the factor (e.g. s=0.5 halves the size).\n" +
+			"\t\t w=<width> h=<height> resize to the given dimensions. If only one of w or h\n" +
+			"\t\t is given, the other is chosen to preserve the aspect ratio.\n" +
+			"\t\t l=<long edge> resizes the longer dimension to the given size (whether it is\n" +
+			"\t\t the width or the height), preserving the aspect ratio. Images whose longer\n" +
+			"\t\t dimension is already within l are left unchanged, unless u=1 allows upscaling.",
+		Factory: ScaleFactory,
+	},
+	"flip": {
+		Desc: "o=(vert|horiz) -- Mirror the image",
+		Usage: "Mirror the image.\n" +
+			"\t\t o=vert flips top-to-bottom, and o=horiz flips left-to-right.",
+		Factory: FlipFactory,
+	},
+	"blur": {
+		Desc: "r=<radius> v=<variance> e=(clamp|wrap|reflect|zero) -- Gaussian blur",
+		Usage: "Blur the image with a Gaussian filter.\n" +
+			"\t\t r=<radius> is the radius of the filter, in pixels (default: 1).\n" +
+			"\t\t v=<variance> is the variance of the Gaussian, i.e. sigma squared (default: 1.0).\n" +
+			"\t\t e=<edge mode> selects how pixels beyond the edges are treated (default: clamp):\n" +
+			"\t\t clamp repeats the edge pixels, wrap takes them from the opposite edge,\n" +
+			"\t\t reflect mirrors the image about its edges, and zero treats them as black.",
+		Factory: BlurFactory,
+	},
+	"sharpen": {
+		Desc: "r=<radius> a=<amount> t=<threshold> m=(unsharp|laplace) e=(clamp|wrap|reflect|zero) -- Sharpen the image",
+		Usage: "Sharpen the image.\n" +
+			"\t\t m=unsharp (the default) uses an unsharp mask, with the parameters:\n" +
+			"\t\t r=<radius> is the radius of the blur, in pixels (default: 1).\n" +
+			"\t\t a=<amount> is the variance of the Gaussian blur, i.e. sigma squared, as per blur's v\n" +
+			"\t\t (default: 1.0). Larger values sharpen coarser detail.\n" +
+			"\t\t t=<threshold> is the smallest difference which is sharpened (default: 0).\n" +
+			"\t\t m=laplace adds the Laplacian instead, and ignores the r, a and t parameters.\n" +
+			"\t\t e=<edge mode> selects how pixels beyond the edges are treated, as per blur (default: clamp).",
+		Factory: SharpenFactory,
+	},
+	"equalize": {
+		Desc: "mode=(luma|rgb) -- Histogram equalization",
+		Usage: "Increase the contrast, by spreading the intensities across the full range.\n" +
+			"\t\t mode=luma (the default) equalizes the luma only, leaving the colors unchanged.\n" +
+			"\t\t mode=rgb equalizes each of the red, green and blue planes independently,\n" +
+			"\t\t which can shift the colors.",
+		Factory: EqualizeFactory,
+	},
+	"denoise": {
+		Desc: "<no arguments> -- Remove noise, preserving edges",
+		Usage: "Remove noise, while preserving edges, using a bilateral filter.\n" +
+			"\t\t The noise level of each image is estimated, and the filter strength chosen to suit,\n" +
+			"\t\t so clean images are left (almost) unchanged.",
+		Factory: DenoiseFactory,
+	},
+	"gray": {
+		Desc: "<no arguments> -- Convert to grayscale",
+		Usage: "Convert the image to grayscale, by replacing each pixel with its luminance.",
+		Factory: GrayFactory,
+	},
+	"crop": {
+		Desc: "x=<int> y=<int> w=<int> h=<int> -- Crop the image",
+		Usage: "Crop the image to the w x h rectangle with its top-left corner at (x,y).\n" +
+			"\t\t x and y default to 0, and w and h are required.\n" +
+			"\t\t The rectangle is clipped to the image. If it lies entirely outside the image,\n" +
+			"\t\t the operation fails (as it would select nothing).",
+		Factory: CropFactory,
+	},
 }
@@ -0,0 +1,452 @@
+// Test file for ops.go
+
+package main
+
+import (
+	"errors"
+	"github.com/smanoharan/go-img-proc/imgproc"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// build the operations from a command line fragment, and apply them to a new image of the given size.
+func applyOperations(t *testing.T, operations string, width, height int) *imgproc.FloatImage {
+	op, err := buildOperations(strings.Fields(operations))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := imgproc.NewFloatImage(width, height)
+	if err := op(img); err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+// check the dimensions of an image
+func assertDims(t *testing.T, img *imgproc.FloatImage, width, height int, title string) {
+	if img.Width != width || img.Height != height || len(img.Ip[0]) != width*height {
+		t.Errorf("%s: exp=%dx%d, act=%dx%d (%d pixels)", title, width, height, img.Width, img.Height, len(img.Ip[0]))
+	}
+}
+
+func TestScaleDoublesDimensions(t *testing.T) {
+	assertDims(t, applyOperations(t, "scale s=2", 5, 3), 10, 6, "scale s=2")
+}
+
+func TestScaleToGivenDimensions(t *testing.T) {
+	assertDims(t, applyOperations(t, "scale w=7 h=4", 5, 3), 7, 4, "scale w=7 h=4")
+	assertDims(t, applyOperations(t, "scale w=10", 20, 8), 10, 4, "scale w=10")
+	assertDims(t, applyOperations(t, "scale s=0.5 + scale h=2", 8, 8), 2, 2, "scale s=0.5 + scale h=2")
+}
+
+func TestScaleLongEdge(t *testing.T) {
+	assertDims(t, applyOperations(t, "scale l=10", 20, 8), 10, 4, "scale l=10 [landscape]")
+	assertDims(t, applyOperations(t, "scale l=10", 8, 20), 4, 10, "scale l=10 [portrait]")
+	assertDims(t, applyOperations(t, "scale l=12", 3, 3), 3, 3, "scale l=12 [smaller]")
+	assertDims(t, applyOperations(t, "scale l=12 u=1", 3, 3), 12, 12, "scale l=12 u=1 [square]")
+}
+
+func TestScaleRejectsInvalidParameters(t *testing.T) {
+	for _, ops := range []string{"scale", "scale s=abc", "scale s=-1", "scale s=2 w=3", "scale x=1", "scale w=1.5", "scale l=-1", "scale l=5 s=2", "scale l=5 h=2", "scale s=2 u=1", "scale l=5 u=abc"} {
+		if _, err := buildOperations(strings.Fields(ops)); err == nil {
+			t.Errorf("buildOperations(%q) should fail", ops)
+		}
+	}
+}
+
+func TestFlipMapsOrientation(t *testing.T) {
+	parsed := collectArgs(strings.Fields("flip o=horiz"))
+	if len(parsed) != 1 || parsed[0].keyword != "flip" {
+		t.Fatalf("collectArgs: unexpected operations %v", parsed)
+	}
+
+	for _, c := range []struct {
+		ops string
+		exp []float32
+	}{
+		{"flip o=horiz", []float32{2, 1, 0, 5, 4, 3}},
+		{"flip o=vert", []float32{3, 4, 5, 0, 1, 2}},
+	} {
+		op, err := buildOperations(strings.Fields(c.ops))
+		if err != nil {
+			t.Fatal(err)
+		}
+		img := imgproc.NewFloatImage(3, 2)
+		for i := range img.Ip[0] {
+			img.Ip[0][i] = float32(i)
+		}
+		op(img)
+		for i, v := range c.exp {
+			if img.Ip[0][i] != v {
+				t.Errorf("%s: exp=%v, act=%v", c.ops, c.exp, img.Ip[0])
+				break
+			}
+		}
+	}
+}
+
+func TestFlipRejectsUnknownOrientation(t *testing.T) {
+	for _, ops := range []string{"flip", "flip o=diag"} {
+		if _, err := buildOperations(strings.Fields(ops)); err == nil {
+			t.Errorf("buildOperations(%q) should fail", ops)
+		}
+	}
+}
+
+func TestBlurIsNotIdentity(t *testing.T) {
+	op, err := buildOperations(strings.Fields("blur r=2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := imgproc.NewFloatImage(5, 5)
+	img.Ip[0][12] = 65535 // a single bright pixel
+	op(img)
+
+	assertDims(t, img, 5, 5, "blur r=2")
+	if img.Ip[0][12] >= 65535 || img.Ip[0][0] <= 0 {
+		t.Errorf("blur r=2 should spread out the bright pixel: center=%f, corner=%f", img.Ip[0][12], img.Ip[0][0])
+	}
+}
+
+func TestBlurEdgeModeChangesEdgePixels(t *testing.T) {
+	blur := func(ops string) *imgproc.FloatImage {
+		op, err := buildOperations(strings.Fields(ops))
+		if err != nil {
+			t.Fatal(err)
+		}
+		img := imgproc.NewFloatImage(6, 6)
+		for j := range img.Ip[0] {
+			if j%6 == 5 {
+				img.Ip[0][j] = 60000 // bright right column: wraps round onto the left edge
+			}
+		}
+		op(img)
+		return img
+	}
+
+	clamped, wrapped, byDefault := blur("blur r=2 e=clamp"), blur("blur r=2 e=wrap"), blur("blur r=2")
+	if clamped.Ip[0][0] != 0 || wrapped.Ip[0][0] <= 0 {
+		t.Errorf("blur: only e=wrap should bring the right edge onto the left: clamp=%f, wrap=%f", clamped.Ip[0][0], wrapped.Ip[0][0])
+	}
+	for j := range clamped.Ip[0] {
+		if clamped.Ip[0][j] != byDefault.Ip[0][j] {
+			t.Fatalf("blur: the default edge mode should be clamp, but [%d] differs", j)
+		}
+	}
+}
+
+func TestSharpenAcceptsEdgeMode(t *testing.T) {
+	for _, ops := range []string{"sharpen e=reflect", "sharpen m=laplace e=zero"} {
+		if _, err := buildOperations(strings.Fields(ops)); err != nil {
+			t.Errorf("buildOperations(%q): %v", ops, err)
+		}
+	}
+	if _, err := buildOperations(strings.Fields("sharpen e=none")); err == nil {
+		t.Error("sharpen should reject an unknown edge mode")
+	}
+}
+
+func TestRepeatedOperationsAreAllApplied(t *testing.T) {
+	op, err := buildOperations(strings.Fields("blur r=1 + blur r=3 v=2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := detailImage()
+	op(img)
+
+	exp := detailImage().GaussianBlurSeparable(1, 1).GaussianBlurSeparable(3, 2)
+	for i := 0; i < 3; i++ {
+		for j := range exp.Ip[i] {
+			if img.Ip[i][j] != exp.Ip[i][j] {
+				t.Fatalf("blur + blur[%d][%d]: exp=%f, act=%f", i, j, exp.Ip[i][j], img.Ip[i][j])
+			}
+		}
+	}
+}
+
+func TestBlurRejectsInvalidParameters(t *testing.T) {
+	for _, ops := range []string{"blur r=-1", "blur v=0", "blur r=x", "blur e=mirror"} {
+		if _, err := buildOperations(strings.Fields(ops)); err == nil {
+			t.Errorf("buildOperations(%q) should fail", ops)
+		}
+	}
+}
+
+// an image with some detail to sharpen
+func detailImage() *imgproc.FloatImage {
+	img := imgproc.NewFloatImage(6, 6)
+	for i := 0; i < 3; i++ {
+		for j := range img.Ip[i] {
+			img.Ip[i][j] = float32((j*7919)%13) * 4000
+		}
+	}
+	return img
+}
+
+func TestSharpenModesAreReachable(t *testing.T) {
+	for _, c := range []struct {
+		ops string
+		exp *imgproc.FloatImage
+	}{
+		{"sharpen r=2 a=1.5 t=10", imgproc.Unsharp(detailImage(), 2, 1.5, 10)},
+		{"sharpen", imgproc.Unsharp(detailImage(), 1, 1.0, 0)},
+		{"sharpen m=laplace", imgproc.SharpenLaplace(detailImage())},
+	} {
+		op, err := buildOperations(strings.Fields(c.ops))
+		if err != nil {
+			t.Fatal(err)
+		}
+		img := detailImage()
+		op(img)
+		if img.Hash() != c.exp.Hash() {
+			t.Errorf("%s: result does not match the library function", c.ops)
+		}
+	}
+}
+
+func TestSharpenRejectsInvalidParameters(t *testing.T) {
+	for _, ops := range []string{"sharpen r=abc", "sharpen a=x", "sharpen t=1e", "sharpen m=blur", "sharpen a=0"} {
+		if _, err := buildOperations(strings.Fields(ops)); err == nil {
+			t.Errorf("buildOperations(%q) should fail", ops)
+		}
+	}
+}
+
+// range of the intensities in a plane
+func planeSpan(plane []float32) float32 {
+	lo, hi := plane[0], plane[0]
+	for _, v := range plane {
+		if v < lo {
+			lo = v
+		} else if v > hi {
+			hi = v
+		}
+	}
+	return hi - lo
+}
+
+func TestEqualizeLumaStretchesContrastKeepingChroma(t *testing.T) {
+	// a dim, low contrast, bluish gradient
+	orig := imgproc.NewFloatImage(32, 2)
+	for j := range orig.Ip[0] {
+		v := float32(20000 + (j%32)*200)
+		orig.Ip[0][j], orig.Ip[1][j], orig.Ip[2][j] = v, v, v*1.2
+	}
+
+	op, err := buildOperations(strings.Fields("equalize mode=luma"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := orig.Clone()
+	op(res)
+
+	before, after := orig.Clone(), res.Clone()
+	before.ToYCrCb()
+	after.ToYCrCb()
+	if planeSpan(after.Ip[0]) < 4*planeSpan(before.Ip[0]) {
+		t.Errorf("equalize: luma range should widen: before=%f, after=%f", planeSpan(before.Ip[0]), planeSpan(after.Ip[0]))
+	}
+	for i := 1; i < 3; i++ {
+		for j := range before.Ip[i] {
+			if d := before.Ip[i][j] - after.Ip[i][j]; d > 1 || d < -1 {
+				t.Fatalf("equalize: chroma plane %d changed at %d: before=%f, after=%f", i, j, before.Ip[i][j], after.Ip[i][j])
+			}
+		}
+	}
+}
+
+func TestEqualizeRejectsUnknownMode(t *testing.T) {
+	if _, err := buildOperations(strings.Fields("equalize mode=hsv")); err == nil {
+		t.Error("equalize should reject an unknown mode")
+	}
+	if _, err := buildOperations(strings.Fields("equalize mode=rgb")); err != nil {
+		t.Error("equalize should accept mode=rgb:", err)
+	}
+}
+
+// an image with a step edge down the middle: dark on the left, bright on the right,
+// plus gray Gaussian noise with the given standard deviation (and a fixed seed).
+func noisyStepImage(width, height int, sigma float64) *imgproc.FloatImage {
+	img := imgproc.NewFloatImage(width, height)
+	rng := rand.New(rand.NewSource(1))
+	for j := range img.Ip[0] {
+		v := float32(15000)
+		if j%width >= width/2 {
+			v = 45000
+		}
+		v += float32(rng.NormFloat64() * sigma)
+		for i := 0; i < 3; i++ {
+			img.Ip[i][j] = v
+		}
+	}
+	return img
+}
+
+// variance of the left (flat) half of a plane
+func leftHalfVariance(plane []float32, width, height int) float64 {
+	sum, sumSq, n := float64(0), float64(0), float64(width/2*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width/2; x++ {
+			v := float64(plane[y*width+x])
+			sum, sumSq = sum+v, sumSq+v*v
+		}
+	}
+	mean := sum / n
+	return sumSq/n - mean*mean
+}
+
+func TestDenoiseSmoothsNoisyImage(t *testing.T) {
+	width, height := 32, 32
+	img := noisyStepImage(width, height, 1000)
+	before := leftHalfVariance(img.Ip[0], width, height)
+
+	op, err := buildOperations(strings.Fields("denoise"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	op(img)
+	after := leftHalfVariance(img.Ip[0], width, height)
+	if after*4 > before {
+		t.Errorf("denoise should reduce the variance of flat regions: before=%f, after=%f", before, after)
+	}
+}
+
+func TestDenoiseBarelyTouchesCleanImage(t *testing.T) {
+	width, height := 32, 32
+	img := noisyStepImage(width, height, 0)
+	orig := img.Clone()
+
+	op, err := buildOperations(strings.Fields("denoise"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	op(img)
+	for j, v := range img.Ip[0] {
+		if d := v - orig.Ip[0][j]; d > 1 || d < -1 {
+			t.Fatalf("denoise changed a clean image at %d: before=%f, after=%f", j, orig.Ip[0][j], v)
+		}
+	}
+}
+
+func TestGrayEndToEnd(t *testing.T) {
+	// a color png
+	src := image.NewRGBA(image.Rect(0, 0, 4, 3))
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 4; x++ {
+			src.Set(x, y, color.RGBA{uint8(60 * x), uint8(80 * y), 200, 255})
+		}
+	}
+	path := filepath.Join(t.TempDir(), "color.png")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(file, src); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	op, err := buildOperations([]string{"gray"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := processFile(path, "png", "", png.Encode, op, sizeLimits{}); err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := os.Open(path + ".png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer output.Close()
+	res, err := png.Decode(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 4; x++ {
+			r, g, b, _ := res.At(x, y).RGBA()
+			if r != g || g != b {
+				t.Errorf("gray: pixel (%d,%d) is not gray: (%d,%d,%d)", x, y, r, g, b)
+			}
+		}
+	}
+}
+
+func TestCropThroughPipeline(t *testing.T) {
+	op, err := buildOperations(strings.Fields("crop x=1 y=1 w=2 h=2 + flip o=horiz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := imgproc.NewFloatImage(4, 4)
+	for i := range img.Ip[0] {
+		img.Ip[0][i] = float32(i)
+	}
+	op(img)
+
+	// the flip sees the cropped image
+	assertDims(t, img, 2, 2, "crop")
+	exp := []float32{6, 5, 10, 9}
+	for i, v := range exp {
+		if img.Ip[0][i] != v {
+			t.Fatalf("crop: exp=%v, act=%v", exp, img.Ip[0])
+		}
+	}
+}
+
+func TestCropAndScaleComposeInTheGivenOrder(t *testing.T) {
+	// crop and scale don't commute: the order is visible in the result's size.
+	// (Repeated, since an unordered composition could happen to match on any one run)
+	for run := 0; run < 20; run++ {
+		assertDims(t, applyOperations(t, "crop w=4 h=4 + scale s=2", 8, 8), 8, 8, "crop + scale")
+		assertDims(t, applyOperations(t, "scale s=2 + crop w=4 h=4", 8, 8), 4, 4, "scale + crop")
+	}
+}
+
+func TestCropClipsToImage(t *testing.T) {
+	assertDims(t, applyOperations(t, "crop x=2 y=1 w=10 h=10", 5, 4), 3, 3, "crop[clipped]")
+}
+
+func TestCropOutsideImageFails(t *testing.T) {
+	for _, c := range []struct{ ops, param string }{{"crop x=9 w=2 h=2", "x"}, {"crop y=4 w=2 h=2", "y"}} {
+		op, err := buildOperations(strings.Fields(c.ops))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = op(imgproc.NewFloatImage(5, 4))
+		var opErr *OpError
+		if !errors.As(err, &opErr) || opErr.Op != "crop" || opErr.Param != c.param {
+			t.Errorf("%s: expected an OpError for crop's %s param, got: %v", c.ops, c.param, err)
+		}
+	}
+}
+
+func TestCropRejectsInvalidParameters(t *testing.T) {
+	for _, ops := range []string{"crop", "crop w=2", "crop w=2 h=0", "crop x=-1 w=2 h=2"} {
+		if _, err := buildOperations(strings.Fields(ops)); err == nil {
+			t.Errorf("buildOperations(%q) should fail", ops)
+		}
+	}
+}
+
+func TestBuildOperationsReturnsOpError(t *testing.T) {
+	_, err := buildOperations(strings.Fields("ident + blur r=-2"))
+	var opErr *OpError
+	if !errors.As(err, &opErr) {
+		t.Fatalf("buildOperations: expected an OpError, got %v", err)
+	}
+	if opErr.Op != "blur" || opErr.Param != "r" {
+		t.Errorf("OpError: exp op=blur, param=r, act op=%s, param=%s", opErr.Op, opErr.Param)
+	}
+
+	_, err = buildOperations(strings.Fields("nosuchop x=1"))
+	if !errors.As(err, &opErr) || opErr.Op != "nosuchop" || !errors.Is(err, ErrUnsupportedOp) {
+		t.Errorf("buildOperations: expected an unsupported OpError for nosuchop, got %v", err)
+	}
+}
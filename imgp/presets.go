@@ -0,0 +1,97 @@
+// Defines named presets: operation chains which can be applied with a single -preset flag.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// for each preset, we need a keyword (i.e. name), a 1-line description, and the operations
+// it expands to, in the same form as the -do arg (e.g. [scale s=2 + flip o=vert]).
+type supportedPreset struct {
+	Desc       string
+	Operations []string
+}
+
+// the registered presets. Presets loaded from a config file are added to (or replace entries in) this map.
+var supported_presets map[string]supportedPreset = map[string]supportedPreset{
+	"web": {
+		Desc:       "-- Shrink to at most 1920 pixels on the long edge, then sharpen slightly (e.g. for publishing online)",
+		Operations: strings.Fields("scale l=1920 + sharpen r=1 a=0.5 t=256"),
+	},
+}
+
+// list the presets, in order of their keywords
+func listSupportedPresets() string {
+	keywords := make([]string, 0, len(supported_presets))
+	for keyword := range supported_presets {
+		keywords = append(keywords, keyword)
+	}
+	sort.Strings(keywords)
+
+	res := bytes.NewBufferString("")
+	for _, keyword := range keywords {
+		res.WriteString(fmt.Sprintln("\t\t", keyword, supported_presets[keyword].Desc))
+	}
+	return res.String()
+}
+
+// expand the named preset into its operations, followed by any further operations.
+// An empty name expands to the operations unchanged.
+func expandPreset(name string, operations []string) ([]string, error) {
+	if name == "" {
+		return operations, nil
+	}
+	preset, found := supported_presets[name]
+	if !found {
+		return nil, fmt.Errorf("%s is not a supported preset", name)
+	}
+
+	res := append([]string{}, preset.Operations...)
+	if len(operations) > 0 {
+		res = append(res, "+")
+		res = append(res, operations...)
+	}
+	return res, nil
+}
+
+// read presets from a config file, and register them.
+// Each line is of the form: <name> <keyword1> par11=v11 ... + <keyword2> par21=v21 ...
+// Blank lines, and lines starting with '#', are ignored.
+// The operations are checked when the preset is used, rather than when it is loaded.
+func loadPresets(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 {
+			return fmt.Errorf("line %d: preset %s has no operations", lineNum, fields[0])
+		}
+		supported_presets[fields[0]] = supportedPreset{
+			Desc:       "<user preset> -- " + strings.Join(fields[1:], " "),
+			Operations: fields[1:],
+		}
+	}
+	return scanner.Err()
+}
+
+// read and register presets from the named config file.
+func loadPresetsFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := loadPresets(file); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+// Test file for presets.go
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// add a preset, for the duration of a test.
+// The returned function removes it again.
+func registerTestPreset(keyword string, operations string) func() {
+	supported_presets[keyword] = supportedPreset{Desc: "test preset", Operations: strings.Fields(operations)}
+	return func() { delete(supported_presets, keyword) }
+}
+
+func TestExpandPresetPrependsOperations(t *testing.T) {
+	defer registerTestPreset("tpreset", "ident + flip o=vert")()
+
+	ops, err := expandPreset("tpreset", strings.Fields("blur r=2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := "ident + flip o=vert + blur r=2"
+	if act := strings.Join(ops, " "); act != exp {
+		t.Errorf("expandPreset: exp=%q, act=%q", exp, act)
+	}
+	if act := describeOperations(ops); act != "ident() -> flip(vert) -> blur(e=clamp,r=2,v=1.0)" {
+		t.Errorf("expandPreset: unexpected operation sequence %q", act)
+	}
+}
+
+func TestBuiltInWebPresetExpands(t *testing.T) {
+	ops, err := expandPreset("web", strings.Fields("flip o=vert"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := "scale(l=1920) -> sharpen(a=0.5,e=clamp,m=unsharp,r=1,t=256.0) -> flip(vert)"
+	if act := describeOperations(ops); act != exp {
+		t.Errorf("expandPreset[web]: exp=%q, act=%q", exp, act)
+	}
+	if _, err := buildOperations(ops); err != nil {
+		t.Errorf("the web preset should build: %v", err)
+	}
+}
+
+func TestListSupportedPresetsIsSorted(t *testing.T) {
+	defer registerTestPreset("aaa", "ident")()
+	defer registerTestPreset("zzz", "ident")()
+
+	listing := listSupportedPresets()
+	first, web, last := strings.Index(listing, " aaa "), strings.Index(listing, " web "), strings.Index(listing, " zzz ")
+	if !(0 <= first && first < web && web < last) {
+		t.Errorf("listSupportedPresets should be sorted by keyword: %q", listing)
+	}
+}
+
+func TestExpandPresetWithoutPreset(t *testing.T) {
+	ops, err := expandPreset("", []string{"ident"})
+	if err != nil || strings.Join(ops, " ") != "ident" {
+		t.Errorf("expandPreset(\"\"): exp=[ident], act=%v (err=%v)", ops, err)
+	}
+	if _, err := expandPreset("nosuchpreset", nil); err == nil {
+		t.Error("expandPreset should reject an unknown preset")
+	}
+}
+
+func TestLoadPresetsRegistersEachLine(t *testing.T) {
+	defer delete(supported_presets, "tsmall")
+	defer delete(supported_presets, "tplain")
+
+	config := "# comment\n\ntsmall scale s=0.5 + flip o=vert\ntplain ident\n"
+	if err := loadPresets(strings.NewReader(config)); err != nil {
+		t.Fatal(err)
+	}
+
+	ops, err := expandPreset("tsmall", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if act := strings.Join(ops, " "); act != "scale s=0.5 + flip o=vert" {
+		t.Errorf("loadPresets[tsmall]: unexpected operations %q", act)
+	}
+	if _, found := supported_presets["tplain"]; !found {
+		t.Error("loadPresets should register tplain")
+	}
+}
+
+func TestLoadPresetsRejectsPresetWithoutOperations(t *testing.T) {
+	defer delete(supported_presets, "tgood")
+	if err := loadPresets(strings.NewReader("tgood ident\ntbad\n")); err == nil {
+		t.Error("loadPresets should reject a preset without operations")
+	}
+}
@@ -0,0 +1,193 @@
+// Implements point operations: adjustments which map each intensity independently.
+package imgproc
+
+import "math"
+
+// clamp an intensity into the range [0,65535].
+func clampIntensity(v float32) float32 {
+	if v < 0 {
+		return 0
+	} else if v > INTENSITY_MAX {
+		return INTENSITY_MAX
+	}
+	return v
+}
+
+// Clamp every intensity, in all three planes, into the range [0,65535].
+// At clamps when reading out pixels anyway, but operations which overshoot (e.g. sharpening)
+// leave out-of-range values in memory, which would otherwise accumulate through chained operations.
+// Modifies the current image.
+func (img *FloatImage) Clamp() {
+	for i := 0; i < 3; i++ {
+		for j, v := range img.Ip[i] {
+			img.Ip[i][j] = clampIntensity(v)
+		}
+	}
+}
+
+// Adjust the brightness of the image, by adding delta to every pixel in all three planes.
+// delta is in the [0,65536) scale: negative deltas darken, and positive deltas brighten.
+// The results are clamped into [0,65535], so chained operations don't accumulate out-of-range values.
+// (A YCrCb or HSV image is brightened in RGB, then converted back).
+// Modifies the current image.
+func (img *FloatImage) Brightness(delta float32) {
+	switch img.Space {
+	case YCrCb:
+		img.ToRGB()
+		defer img.ToYCrCb()
+	case HSV:
+		img.ToRGB()
+		defer img.ToHSV()
+	}
+
+	img.Apply(func(v ...float32) float32 { return clampIntensity(v[0] + delta) })
+}
+
+// Adjust the brightness as per FloatImage.Brightness, except return a new image
+// rather than modifying the original image.
+func Brightness(img *FloatImage, delta float32) *FloatImage {
+	result := img.Clone() // init new image
+	result.Brightness(delta)
+	return result
+}
+
+// mid-gray intensity, about which Contrast scales.
+const MID_GRAY = 32768
+
+// Adjust the contrast of the image, by scaling the distance of every pixel (in all three planes)
+// from mid-gray by factor: i.e. new = mid + (old - mid) * factor.
+// A factor of 1 leaves the image unchanged, a factor above 1 increases the contrast,
+// and a factor of 0 flattens the image to mid-gray.
+// The results are clamped into [0,65535].
+// (A YCrCb or HSV image is adjusted in RGB, then converted back).
+// Modifies the current image.
+func (img *FloatImage) Contrast(factor float32) {
+	switch img.Space {
+	case YCrCb:
+		img.ToRGB()
+		defer img.ToYCrCb()
+	case HSV:
+		img.ToRGB()
+		defer img.ToHSV()
+	}
+
+	img.Apply(func(v ...float32) float32 { return clampIntensity(MID_GRAY + (v[0]-MID_GRAY)*factor) })
+}
+
+// Adjust the contrast as per FloatImage.Contrast, except return a new image
+// rather than modifying the original image.
+func Contrast(img *FloatImage, factor float32) *FloatImage {
+	result := img.Clone() // init new image
+	result.Contrast(factor)
+	return result
+}
+
+// Apply gamma correction to the image, mapping every pixel (in all three planes)
+// to max * (v / max)^(1/gamma), where max is 65535.
+// A gamma above 1 brightens the midtones, and a gamma below 1 darkens them;
+// black and white are unchanged. A gamma <= 0 is invalid, and leaves the image unchanged.
+// (A YCrCb or HSV image is corrected in RGB, then converted back).
+// Modifies the current image.
+func (img *FloatImage) Gamma(gamma float64) {
+	if gamma <= 0 {
+		return
+	}
+
+	switch img.Space {
+	case YCrCb:
+		img.ToRGB()
+		defer img.ToYCrCb()
+	case HSV:
+		img.ToRGB()
+		defer img.ToHSV()
+	}
+
+	exponent := 1 / gamma
+	img.Apply(func(v ...float32) float32 {
+		return INTENSITY_MAX * float32(math.Pow(float64(clampIntensity(v[0])/INTENSITY_MAX), exponent))
+	})
+}
+
+// Apply gamma correction as per FloatImage.Gamma, except return a new image
+// rather than modifying the original image.
+func Gamma(img *FloatImage, gamma float64) *FloatImage {
+	result := img.Clone() // init new image
+	result.Gamma(gamma)
+	return result
+}
+
+// ToneMapKind selects the curve used by ToneMap to compress over-range intensities.
+type ToneMapKind int
+
+const (
+	// Reinhard maps each normalized intensity v to v/(1+v).
+	// Reference: E. Reinhard et al., (2002). "Photographic tone reproduction for digital images".
+	Reinhard ToneMapKind = iota
+
+	// Filmic uses a fit of the ACES filmic curve, (v(2.51v+0.03)) / (v(2.43v+0.59)+0.14),
+	// which has more contrast than Reinhard, with a gentle toe in the shadows.
+	// Reference: K. Narkowicz, (2016). "ACES Filmic Tone Mapping Curve".
+	Filmic
+)
+
+// Compress the intensities of the image (in all three planes) into [0,65535] with a smooth curve,
+// rather than hard clipping them: e.g. for displaying an image whose highlights have been
+// pushed over range (above 65535), keeping the detail in those highlights.
+// Intensities are normalized (so 65535 is 1) before the operator's curve is applied, and scaled back after.
+// Negative intensities are treated as black. Both curves darken the in-range intensities as well,
+// so the result may need brightening (e.g. with Gamma).
+// (A YCrCb or HSV image is tone mapped in RGB, then converted back).
+// Modifies the current image.
+func (img *FloatImage) ToneMap(operator ToneMapKind) {
+	switch img.Space {
+	case YCrCb:
+		img.ToRGB()
+		defer img.ToYCrCb()
+	case HSV:
+		img.ToRGB()
+		defer img.ToHSV()
+	}
+
+	img.Apply(func(v ...float32) float32 {
+		x := math.Max(0, float64(v[0]/INTENSITY_MAX))
+		var y float64
+		switch operator {
+		case Filmic:
+			y = (x * (2.51*x + 0.03)) / (x*(2.43*x+0.59) + 0.14)
+		default:
+			y = x / (1 + x)
+		}
+		return clampIntensity(float32(y) * INTENSITY_MAX)
+	})
+}
+
+// Tone map the image as per FloatImage.ToneMap, except return a new image
+// rather than modifying the original image.
+func ToneMap(img *FloatImage, operator ToneMapKind) *FloatImage {
+	result := img.Clone() // init new image
+	result.ToneMap(operator)
+	return result
+}
+
+// Binarize the image: set all three planes of each pixel to white (65535) if its luminance
+// is at least level, and to black (0) otherwise. (A pixel exactly at the level becomes white).
+// The result is an RGB image, whatever the color space of the original.
+// Modifies the current image.
+func (img *FloatImage) Threshold(level float32) {
+	for i := range img.Ip[0] {
+		v := float32(0)
+		if luminance(img.rgbAt(i)) >= level {
+			v = INTENSITY_MAX
+		}
+		img.Ip[0][i], img.Ip[1][i], img.Ip[2][i] = v, v, v
+	}
+	img.Space = RGB
+}
+
+// Binarize the image as per FloatImage.Threshold, except return a new image
+// rather than modifying the original image.
+func Threshold(img *FloatImage, level float32) *FloatImage {
+	result := img.Clone() // init new image
+	result.Threshold(level)
+	return result
+}
@@ -0,0 +1,196 @@
+// Test file for adjust.go
+
+package imgproc
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+// check that every intensity of the image lies within [0,65535]
+func assertInRange(t *testing.T, img *FloatImage, title string) {
+	for i := 0; i < 3; i++ {
+		for j, v := range img.Ip[i] {
+			if v < 0 || v > INTENSITY_MAX {
+				t.Errorf("%s[plane %d][%d]: %f is out of range", title, i, j, v)
+				return
+			}
+		}
+	}
+}
+
+func TestClampMatchesAtOfOverSharpenedImage(t *testing.T) {
+	// sharpen without clamping, leaving overshoots on both sides of each edge
+	img := checkerboardImage(8, 8, 2)
+	laplacian := img.ConvolveClamp(LaplaceSpherical())
+	img.Apply(func(v ...float32) float32 { return v[0] + v[1] }, laplacian)
+
+	lo, hi := img.Ip[0][0], img.Ip[0][0]
+	for _, v := range img.Ip[0] {
+		lo, hi = float32(math.Min(float64(lo), float64(v))), float32(math.Max(float64(hi), float64(v)))
+	}
+	assert(t, lo < 0 && hi > INTENSITY_MAX, fmt.Sprintf("sharpening should overshoot: range=[%f,%f]", lo, hi))
+
+	unclamped := img.Clone()
+	img.Clamp()
+	assertInRange(t, img, "Clamp")
+	for y := 0; y < img.Height; y++ {
+		for x := 0; x < img.Width; x++ {
+			assert(t, img.At(x, y) == unclamped.At(x, y),
+				fmt.Sprintf("Clamp[%d,%d]: exp=%v, act=%v", x, y, unclamped.At(x, y), img.At(x, y)))
+		}
+	}
+}
+
+func TestSharpeningClampsResult(t *testing.T) {
+	img := checkerboardImage(8, 8, 2)
+	assertInRange(t, SharpenLaplace(img), "SharpenLaplace")
+	assertInRange(t, Unsharp(img, 2, 2, 0), "Unsharp")
+}
+
+func TestBrightnessShiftsMidRange(t *testing.T) {
+	img := grayImage(3, 1, 1000, 20000, 40000)
+	res := Brightness(img, 5000)
+	for i := 0; i < 3; i++ {
+		assertFloat32SliceEquals(t, []float32{6000, 25000, 45000}, res.Ip[i], "Brightness[+5000]")
+	}
+	assertFloat32SliceEquals(t, []float32{1000, 20000, 40000}, img.Ip[0], "Brightness.original")
+}
+
+// check that an adjustment gives the same result on a YCrCb or HSV image as on the RGB original,
+// and keeps the color space of the image.
+func assertAdjustsInRGB(t *testing.T, adjust func(img *FloatImage), title string) {
+	exp := testColorsImage()
+	adjust(exp)
+	for space, convert := range []func(*FloatImage){YCrCb: (*FloatImage).ToYCrCb, HSV: (*FloatImage).ToHSV} {
+		if convert == nil {
+			continue
+		}
+		img := testColorsImage()
+		convert(img)
+		adjust(img)
+		assert(t, img.Space == ColorSpace(space), fmt.Sprintf("%s[space=%d] should keep the color space", title, space))
+		img.ToRGB()
+		for i := 0; i < 3; i++ {
+			assertPlanesClose(t, exp.Ip[i], img.Ip[i], 1, fmt.Sprintf("%s[space=%d][plane %d]", title, space, i))
+		}
+	}
+}
+
+func TestBrightnessOfNonRGBImageAdjustsInRGB(t *testing.T) {
+	assertAdjustsInRGB(t, func(img *FloatImage) { img.Brightness(5000) }, "Brightness")
+}
+
+func TestBrightnessSaturatesAtTop(t *testing.T) {
+	img := grayImage(2, 1, 60000, 65535)
+	img.Brightness(10000)
+	assertFloat32SliceEquals(t, []float32{65535, 65535}, img.Ip[0], "Brightness[saturate-top]")
+}
+
+func TestBrightnessSaturatesAtBottom(t *testing.T) {
+	img := grayImage(2, 1, 3000, 0)
+	img.Brightness(-5000)
+	assertFloat32SliceEquals(t, []float32{0, 0}, img.Ip[1], "Brightness[saturate-bottom]")
+}
+
+func TestContrastOfNonRGBImageAdjustsInRGB(t *testing.T) {
+	assertAdjustsInRGB(t, func(img *FloatImage) { img.Contrast(1.5) }, "Contrast")
+}
+
+func TestContrastOfOneIsIdentity(t *testing.T) {
+	img := grayImage(4, 1, 0, 1234, 32768, 65535)
+	res := Contrast(img, 1)
+	for i := 0; i < 3; i++ {
+		assertFloat32SliceEquals(t, img.Ip[i], res.Ip[i], "Contrast[1]")
+	}
+}
+
+func TestContrastOfZeroFlattensToMidGray(t *testing.T) {
+	img := grayImage(3, 1, 0, 20000, 65535)
+	img.Contrast(0)
+	assertFloat32SliceEquals(t, []float32{MID_GRAY, MID_GRAY, MID_GRAY}, img.Ip[2], "Contrast[0]")
+}
+
+func TestContrastOfTwoPushesApart(t *testing.T) {
+	img := grayImage(4, 1, 22768, 42768, 2768, 62768)
+	img.Contrast(2)
+	assertFloat32SliceEquals(t, []float32{12768, 52768, 0, 65535}, img.Ip[0], "Contrast[2]")
+}
+
+func TestGammaOfNonRGBImageAdjustsInRGB(t *testing.T) {
+	assertAdjustsInRGB(t, func(img *FloatImage) { img.Gamma(0.5) }, "Gamma")
+}
+
+func TestGammaOfOneIsIdentity(t *testing.T) {
+	img := grayImage(4, 1, 0, 1000, 32768, 65535)
+	res := Gamma(img, 1)
+	for i := 0; i < 3; i++ {
+		for j, v := range res.Ip[i] {
+			assert(t, math.Abs(float64(v-img.Ip[i][j])) < 0.01, fmt.Sprintf("Gamma[1][%d]: exp=%f, act=%f", j, img.Ip[i][j], v))
+		}
+	}
+}
+
+func TestGammaBrightensMidtones(t *testing.T) {
+	img := grayImage(3, 1, 0, INTENSITY_MAX/2, INTENSITY_MAX)
+	img.Gamma(2.2)
+
+	// 0.5^(1/2.2) ~= 0.7297
+	exp := []float32{0, 0.7297 * INTENSITY_MAX, INTENSITY_MAX}
+	for j, v := range img.Ip[1] {
+		assert(t, math.Abs(float64(v-exp[j])) < 5, fmt.Sprintf("Gamma[2.2][%d]: exp=%f, act=%f", j, exp[j], v))
+	}
+}
+
+func TestGammaIgnoresNonPositive(t *testing.T) {
+	img := grayImage(2, 1, 1000, 50000)
+	img.Gamma(0)
+	img.Gamma(-1)
+	assertFloat32SliceEquals(t, []float32{1000, 50000}, img.Ip[0], "Gamma[<=0]")
+}
+
+func TestThresholdSplitsAboveAndBelow(t *testing.T) {
+	img := grayImage(4, 1, 0, 29999, 30001, 65535)
+	res := Threshold(img, 30000)
+	for i := 0; i < 3; i++ {
+		assertFloat32SliceEquals(t, []float32{0, 0, 65535, 65535}, res.Ip[i], "Threshold[30000]")
+	}
+}
+
+func TestThresholdUsesLuminance(t *testing.T) {
+	// pure green is brighter than pure blue of the same intensity
+	img := NewFloatImage(2, 1)
+	img.Ip[1][0], img.Ip[2][1] = 40000, 40000
+	img.Threshold(10000)
+	assertFloat32SliceEquals(t, []float32{65535, 0}, img.Ip[0], "Threshold[luminance]")
+}
+
+func TestThresholdAtLevelIsWhite(t *testing.T) {
+	img := grayImage(1, 1, 30000)
+	img.Threshold(30000)
+	assertFloat32Equals(t, 65535, img.Ip[0][0], "Threshold[equal]")
+}
+
+func TestToneMapOfNonRGBImageMapsInRGB(t *testing.T) {
+	assertAdjustsInRGB(t, func(img *FloatImage) { img.ToneMap(Reinhard) }, "ToneMap[Reinhard]")
+	assertAdjustsInRGB(t, func(img *FloatImage) { img.ToneMap(Filmic) }, "ToneMap[Filmic]")
+}
+
+func TestToneMapCompressesOverRangeHighlights(t *testing.T) {
+	// highlights at 2x and 4x white: clipping would make both flat white
+	img := grayImage(3, 1, 2*INTENSITY_MAX, 4*INTENSITY_MAX, -1000)
+	for _, operator := range []ToneMapKind{Reinhard, Filmic} {
+		res := ToneMap(img, operator)
+		bright, brighter := res.Ip[0][0], res.Ip[0][1]
+		assert(t, bright < brighter && brighter < INTENSITY_MAX,
+			fmt.Sprintf("ToneMap[%d]: highlights should stay distinct, below white: %f, %f", operator, bright, brighter))
+		assertFloat32Equals(t, 0, res.Ip[0][2], fmt.Sprintf("ToneMap[%d].negative", operator))
+	}
+}
+
+func TestToneMapReinhard(t *testing.T) {
+	img := grayImage(3, 1, 0, INTENSITY_MAX, 3*INTENSITY_MAX)
+	img.ToneMap(Reinhard)
+	assertPlanesClose(t, []float32{0, INTENSITY_MAX / 2, INTENSITY_MAX * 3 / 4}, img.Ip[0], 0.5, "ToneMap[Reinhard]")
+}
@@ -0,0 +1,108 @@
+// Test file for blur.go
+
+package imgproc
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+// build a mask whose intensity rises linearly from black to white across columns [x0,x1)
+func rampMask(width, height, x0, x1 int) *FloatImage {
+	mask := NewFloatImage(width, height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := float32(0)
+			if x >= x1 {
+				v = INTENSITY_MAX
+			} else if x > x0 {
+				v = INTENSITY_MAX * float32(x-x0) / float32(x1-x0)
+			}
+			for i := 0; i < 3; i++ {
+				mask.Ip[i][y*width+x] = v
+			}
+		}
+	}
+	return mask
+}
+
+func TestMaskedBlurWithHardMaskHasSharpBoundary(t *testing.T) {
+	width, height := 16, 8
+	img := checkerboardImage(width, height, 1)
+	mask := rampMask(width, height, 7, 8) // black up to column 7, white from column 8
+	blurred := img.ConvolveClamp(GaussianFilterKernel(3, 1))
+	res := img.MaskedBlur(mask, 1)
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			exp := img.Ip[0][y*width+x]
+			if x >= 8 {
+				exp = blurred.Ip[0][y*width+x]
+			}
+			assertFloat32Equals(t, exp, res.Ip[0][y*width+x], fmt.Sprintf("MaskedBlur[hard](%d,%d)", x, y))
+		}
+	}
+}
+
+func TestMaskedBlurWithFeatheredMaskIsGradual(t *testing.T) {
+	width, height := 16, 8
+	img := checkerboardImage(width, height, 1)
+	mask := rampMask(width, height, 4, 12)
+	blurred := img.ConvolveClamp(GaussianFilterKernel(3, 1))
+	res := img.MaskedBlur(mask, 1)
+
+	// the fraction of the way from the original to the blurred pixel follows the mask
+	y := 4
+	for x := 4; x <= 12; x++ {
+		i := y*width + x
+		frac := float64((res.Ip[0][i] - img.Ip[0][i]) / (blurred.Ip[0][i] - img.Ip[0][i]))
+		exp := float64(x-4) / 8
+		assert(t, math.Abs(frac-exp) < 1e-3, fmt.Sprintf("MaskedBlur[feathered](%d): exp=%f, act=%f", x, exp, frac))
+	}
+}
+
+func TestGaussianBlurSeparableMatchesDense(t *testing.T) {
+	img := checkerboardImage(9, 7, 2)
+	for i := range img.Ip[1] {
+		img.Ip[1][i] = float32(i * 1000) // a ramp, so the planes differ
+	}
+
+	dense := img.ConvolveClamp(GaussianFilterKernel(3, 2))
+	separable := img.GaussianBlurSeparable(3, 2)
+	for i := 0; i < 3; i++ {
+		assertPlanesClose(t, dense.Ip[i], separable.Ip[i], 0.1, fmt.Sprintf("GaussianBlurSeparable[plane %d]", i))
+	}
+}
+
+func TestGaussianBlurSeparableEdgeMatchesDense(t *testing.T) {
+	img := checkerboardImage(9, 7, 2)
+	for i := range img.Ip[1] {
+		img.Ip[1][i] = float32(i * 1000) // a ramp, so the planes differ
+	}
+
+	for _, mode := range []EdgeMode{EdgeClamp, EdgeWrap, EdgeReflect, EdgeZero} {
+		dense := img.ConvolveEdge(GaussianFilterKernel(3, 2), mode)
+		separable := img.GaussianBlurSeparableEdge(3, 2, mode)
+		for i := 0; i < 3; i++ {
+			assertPlanesClose(t, dense.Ip[i], separable.Ip[i], 0.1, fmt.Sprintf("GaussianBlurSeparableEdge[mode %d, plane %d]", mode, i))
+		}
+	}
+}
+
+func BenchmarkGaussianBlurDense(b *testing.B) {
+	img := checkerboardImage(128, 128, 4)
+	kernel := GaussianFilterKernel(10, 16)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		img.ConvolveClamp(kernel)
+	}
+}
+
+func BenchmarkGaussianBlurSeparable(b *testing.B) {
+	img := checkerboardImage(128, 128, 4)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		img.GaussianBlurSeparable(10, 16)
+	}
+}
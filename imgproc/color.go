@@ -0,0 +1,495 @@
+// Implements colour operations.
+package imgproc
+
+import (
+	"fmt"
+	"math"
+)
+
+// luma weights (ITU-R BT.601) for computing luminance from RGB.
+const (
+	LUMA_R = float32(0.299)
+	LUMA_G = float32(0.587)
+	LUMA_B = float32(0.114)
+)
+
+// compute the luminance of an RGB pixel.
+func luminance(r, g, b float32) float32 {
+	return LUMA_R*r + LUMA_G*g + LUMA_B*b
+}
+
+// ColorSpace identifies how the three intensity planes of a FloatImage are interpreted.
+type ColorSpace int
+
+const (
+	// the planes are red, green and blue (the default)
+	RGB ColorSpace = iota
+
+	// the planes are luma (Y), red-difference chroma (Cr) and blue-difference chroma (Cb),
+	// as per ITU-R BT.601 (full range, as used by JPEG).
+	// The chroma planes are offset by CHROMA_OFFSET, so that all planes are within [0,65536).
+	YCrCb
+
+	// the planes are hue (H, in degrees, in [0,360)), saturation (S, in [0,1])
+	// and value (V, in [0,1]). Note these are not in the [0,65536) range of the other spaces.
+	HSV
+)
+
+// offset added to the chroma planes, so that zero chroma lies in the middle of the range.
+const CHROMA_OFFSET = float32(32768)
+
+// convert an RGB pixel into YCrCb (ITU-R BT.601).
+func rgbToYCrCb(r, g, b float32) (y, cr, cb float32) {
+	y = luminance(r, g, b)
+	cr = 0.5*r - 0.418688*g - 0.081312*b + CHROMA_OFFSET
+	cb = -0.168736*r - 0.331264*g + 0.5*b + CHROMA_OFFSET
+	return
+}
+
+// convert a YCrCb pixel into RGB (ITU-R BT.601).
+func yCrCbToRGB(y, cr, cb float32) (r, g, b float32) {
+	cr, cb = cr-CHROMA_OFFSET, cb-CHROMA_OFFSET
+	r = y + 1.402*cr
+	g = y - 0.344136*cb - 0.714136*cr
+	b = y + 1.772*cb
+	return
+}
+
+// convert an RGB pixel into HSV.
+// For achromatic pixels (i.e. grays, where the hue is undefined), the hue is set to 0.
+func rgbToHSV(r, g, b float32) (h, s, v float32) {
+	hi, lo := r, r
+	if g > hi {
+		hi = g
+	} else if g < lo {
+		lo = g
+	}
+	if b > hi {
+		hi = b
+	} else if b < lo {
+		lo = b
+	}
+
+	v = hi / INTENSITY_MAX
+	delta := hi - lo
+	if hi <= 0 || delta <= 0 {
+		return 0, 0, v // achromatic
+	}
+	s = delta / hi
+
+	// hue: which sixth of the color wheel, from the largest component
+	switch hi {
+	case r:
+		h = 60 * (g - b) / delta
+	case g:
+		h = 60 * ((b-r)/delta + 2)
+	default:
+		h = 60 * ((r-g)/delta + 4)
+	}
+	if h < 0 {
+		h += 360
+	}
+	return
+}
+
+// convert an HSV pixel into RGB.
+func hsvToRGB(h, s, v float32) (r, g, b float32) {
+	chroma := v * s
+	sector := float64(h) / 60
+	x := chroma * float32(1-math.Abs(math.Mod(sector, 2)-1))
+	m := v - chroma
+
+	switch int(math.Floor(sector)) % 6 {
+	case 0:
+		r, g, b = chroma, x, 0
+	case 1:
+		r, g, b = x, chroma, 0
+	case 2:
+		r, g, b = 0, chroma, x
+	case 3:
+		r, g, b = 0, x, chroma
+	case 4:
+		r, g, b = x, 0, chroma
+	default:
+		r, g, b = chroma, 0, x
+	}
+	return (r + m) * INTENSITY_MAX, (g + m) * INTENSITY_MAX, (b + m) * INTENSITY_MAX
+}
+
+// find the RGB values of the pixel at index i, whatever the color space of the image.
+func (img *FloatImage) rgbAt(i int) (r, g, b float32) {
+	p0, p1, p2 := img.Ip[0][i], img.Ip[1][i], img.Ip[2][i]
+	switch img.Space {
+	case YCrCb:
+		return yCrCbToRGB(p0, p1, p2)
+	case HSV:
+		return hsvToRGB(p0, p1, p2)
+	}
+	return p0, p1, p2
+}
+
+// set the pixel at index i to the given RGB colour, converted into the color space of the image
+// (i.e. the inverse of rgbAt).
+func (img *FloatImage) setRGBAt(i int, r, g, b float32) {
+	p0, p1, p2 := r, g, b
+	switch img.Space {
+	case YCrCb:
+		p0, p1, p2 = rgbToYCrCb(r, g, b)
+	case HSV:
+		p0, p1, p2 = rgbToHSV(r, g, b)
+	}
+	img.Ip[0][i], img.Ip[1][i], img.Ip[2][i] = p0, p1, p2
+}
+
+// apply a conversion to every pixel of the image, replacing its three plane values.
+func (img *FloatImage) convertPixels(convert func(p0, p1, p2 float32) (float32, float32, float32)) {
+	p0, p1, p2 := img.Ip[0], img.Ip[1], img.Ip[2]
+	for i := range p0 {
+		p0[i], p1[i], p2[i] = convert(p0[i], p1[i], p2[i])
+	}
+}
+
+// Convert the image into the YCrCb color space, so that luma-only operations
+// can be applied to the Y plane (plane 0) without touching the chroma planes.
+// Does nothing if the image is already YCrCb.
+// Modifies the current image.
+func (img *FloatImage) ToYCrCb() {
+	if img.Space == YCrCb {
+		return
+	}
+	img.ToRGB()
+	img.convertPixels(rgbToYCrCb)
+	img.Space = YCrCb
+}
+
+// Convert the image into the HSV color space, so that hue and saturation can be adjusted directly:
+// plane 0 holds the hue (in degrees), plane 1 the saturation and plane 2 the value.
+// Does nothing if the image is already HSV.
+// Modifies the current image.
+func (img *FloatImage) ToHSV() {
+	if img.Space == HSV {
+		return
+	}
+	img.ToRGB()
+	img.convertPixels(rgbToHSV)
+	img.Space = HSV
+}
+
+// Convert the image back into the RGB color space.
+// Does nothing if the image is already RGB.
+// Modifies the current image.
+func (img *FloatImage) ToRGB() {
+	switch img.Space {
+	case YCrCb:
+		img.convertPixels(yCrCbToRGB)
+	case HSV:
+		img.convertPixels(hsvToRGB)
+	}
+	img.Space = RGB
+}
+
+// Desaturate the image: replace each pixel with its luminance (0.299*R + 0.587*G + 0.114*B),
+// in all three planes. (For a YCrCb image, the chroma planes are neutralised instead).
+// Modifies the current image.
+func (img *FloatImage) Grayscale() {
+	if img.Space == HSV {
+		img.ToRGB()
+		defer img.ToHSV()
+	}
+
+	if img.Space == YCrCb {
+		// the luma is already separate: just remove the chroma
+		for i := 1; i < 3; i++ {
+			for j := range img.Ip[i] {
+				img.Ip[i][j] = CHROMA_OFFSET
+			}
+		}
+		return
+	}
+
+	r, g, b := img.Ip[0], img.Ip[1], img.Ip[2]
+	for i := range r {
+		luma := luminance(r[i], g[i], b[i])
+		r[i], g[i], b[i] = luma, luma, luma
+	}
+}
+
+// Desaturate the image as per FloatImage.Grayscale, except return a new image
+// rather than modifying the original image.
+func Grayscale(img *FloatImage) *FloatImage {
+	result := img.Clone() // init new image
+	result.Grayscale()
+	return result
+}
+
+// Reorder the three planes, so that plane i of the result is plane order[i] of the original,
+// e.g. {2,1,0} swaps the red and blue planes (RGB to BGR), and {0,1,2} leaves the image unchanged.
+// Only the plane slices are swapped: no pixels are copied. The color space is unchanged.
+// Returns an error (leaving the image unchanged) if order is not a permutation of {0,1,2}.
+// Modifies the current image.
+func (img *FloatImage) Swizzle(order [3]int) error {
+	var seen [3]bool
+	for _, p := range order {
+		if p < 0 || p > 2 || seen[p] {
+			return fmt.Errorf("swizzle order %v is not a permutation of {0,1,2}", order)
+		}
+		seen[p] = true
+	}
+
+	img.Ip = [3][]float32{img.Ip[order[0]], img.Ip[order[1]], img.Ip[order[2]]}
+	return nil
+}
+
+// luminance weights (ITU-R BT.709) for linear-light RGB.
+const (
+	LINEAR_LUMA_R = 0.2126
+	LINEAR_LUMA_G = 0.7152
+	LINEAR_LUMA_B = 0.0722
+)
+
+// convert a (gamma-encoded) sRGB intensity in [0,65535] into linear light, in [0,1].
+func srgbToLinear(v float32) float64 {
+	c := float64(v / INTENSITY_MAX)
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return math.Pow((c+0.055)/1.055, 2.4)
+}
+
+// convert linear light in [0,1] back into a (gamma-encoded) sRGB intensity in [0,65535].
+func linearToSRGB(c float64) float32 {
+	if c <= 0.0031308 {
+		c *= 12.92
+	} else {
+		c = 1.055*math.Pow(c, 1/2.4) - 0.055
+	}
+	return clampIntensity(float32(c) * INTENSITY_MAX)
+}
+
+// Desaturate the image, as per Grayscale, except that the luminance is computed in linear light:
+// each pixel is linearized (from sRGB), weighted by the Rec.709 coefficients
+// (0.2126*R + 0.7152*G + 0.0722*B), then re-encoded into sRGB.
+// Grayscale weights the gamma-encoded values, which makes saturated colors (especially reds and blues)
+// too dark; this variant matches their perceived lightness more closely.
+// (A YCrCb or HSV image is desaturated in RGB, then converted back).
+// Modifies the current image.
+func (img *FloatImage) GrayscaleLinear() {
+	switch img.Space {
+	case YCrCb:
+		img.ToRGB()
+		defer img.ToYCrCb()
+	case HSV:
+		img.ToRGB()
+		defer img.ToHSV()
+	}
+
+	img.convertPixels(func(r, g, b float32) (float32, float32, float32) {
+		luma := linearToSRGB(LINEAR_LUMA_R*srgbToLinear(r) + LINEAR_LUMA_G*srgbToLinear(g) + LINEAR_LUMA_B*srgbToLinear(b))
+		return luma, luma, luma
+	})
+}
+
+// Desaturate the image as per FloatImage.GrayscaleLinear, except return a new image
+// rather than modifying the original image.
+func GrayscaleLinear(img *FloatImage) *FloatImage {
+	result := img.Clone() // init new image
+	result.GrayscaleLinear()
+	return result
+}
+
+// the standard sepia toning matrix: a weighted sum of R, G and B for each output plane.
+var sepiaMatrix = [3][3]float32{
+	{0.393, 0.769, 0.189},
+	{0.349, 0.686, 0.168},
+	{0.272, 0.534, 0.131},
+}
+
+// Apply a sepia tone to the image, by transforming each pixel by the standard sepia matrix,
+// e.g. R' = 0.393*R + 0.769*G + 0.189*B, clamping the results into [0,65535].
+// (A YCrCb or HSV image is toned in RGB, then converted back).
+// Modifies the current image.
+func (img *FloatImage) Sepia() {
+	switch img.Space {
+	case YCrCb:
+		img.ToRGB()
+		defer img.ToYCrCb()
+	case HSV:
+		img.ToRGB()
+		defer img.ToHSV()
+	}
+
+	// each output plane depends on all three inputs, so compute the whole triple before writing it
+	img.convertPixels(func(r, g, b float32) (float32, float32, float32) {
+		var out [3]float32
+		for i, row := range sepiaMatrix {
+			out[i] = clampIntensity(row[0]*r + row[1]*g + row[2]*b)
+		}
+		return out[0], out[1], out[2]
+	})
+}
+
+// Apply a sepia tone as per FloatImage.Sepia, except return a new image
+// rather than modifying the original image.
+func Sepia(img *FloatImage) *FloatImage {
+	result := img.Clone() // init new image
+	result.Sepia()
+	return result
+}
+
+// Hermite interpolation from 0 (at or below lo) to 1 (at or above hi), with zero slope at both ends.
+func smoothstep(lo, hi, v float32) float32 {
+	t := (v - lo) / (hi - lo)
+	if t <= 0 {
+		return 0
+	} else if t >= 1 {
+		return 1
+	}
+	return t * t * (3 - 2*t)
+}
+
+// Split a luma (in [0,65535]) into the weights of the shadow, midtone and highlight zones, which sum to 1.
+// The shadow weight falls smoothly from 1 (black) to 0 (mid gray), and the highlight weight rises
+// smoothly from 0 (mid gray) to 1 (white): the midtones take the rest, peaking at mid gray.
+func lumaZones(luma float32) (shadow, mid, highlight float32) {
+	shadow = 1 - smoothstep(0, MID_GRAY, luma)
+	highlight = smoothstep(MID_GRAY, INTENSITY_MAX, luma)
+	return shadow, 1 - shadow - highlight, highlight
+}
+
+// scale the saturation of an RGB pixel by sat, i.e. move it away from (sat > 1) or
+// towards (sat < 1) the gray of the same luma, clamping the result into [0,65535].
+func saturate(r, g, b, luma, sat float32) (float32, float32, float32) {
+	return clampIntensity(luma + sat*(r-luma)), clampIntensity(luma + sat*(g-luma)), clampIntensity(luma + sat*(b-luma))
+}
+
+// Scale the saturation of the shadows, midtones and highlights of the image by separate multipliers
+// (e.g. shadowSat = 0.5 halves the saturation of the shadows; 1 leaves it unchanged; 0 removes it).
+// Each pixel's multiplier blends the three, weighted by the pixel's luma, so there are no hard
+// transitions between the zones: black takes shadowSat, mid gray takes midSat, and white takes highSat.
+// (A YCrCb or HSV image is adjusted in RGB, then converted back).
+// Modifies the current image.
+func (img *FloatImage) SelectiveSaturation(shadowSat, midSat, highSat float32) {
+	switch img.Space {
+	case YCrCb:
+		img.ToRGB()
+		defer img.ToYCrCb()
+	case HSV:
+		img.ToRGB()
+		defer img.ToHSV()
+	}
+
+	img.convertPixels(func(r, g, b float32) (float32, float32, float32) {
+		luma := luminance(r, g, b)
+		shadow, mid, highlight := lumaZones(luma)
+		return saturate(r, g, b, luma, shadow*shadowSat+mid*midSat+highlight*highSat)
+	})
+}
+
+// Scale the saturation as per FloatImage.SelectiveSaturation, except return a new image
+// rather than modifying the original image.
+func SelectiveSaturation(img *FloatImage, shadowSat, midSat, highSat float32) *FloatImage {
+	result := img.Clone() // init new image
+	result.SelectiveSaturation(shadowSat, midSat, highSat)
+	return result
+}
+
+// Tint the shadows of the image towards one color, and the highlights towards another
+// (e.g. the "teal and orange" grade: teal shadows, and orange highlights).
+// The shadow and highlight tints are RGB colors, in [0,65535]: only their chroma is added (i.e. each
+// tint's difference from the gray of the same luma), so a more saturated tint gives a stronger effect,
+// and a gray tint has no effect. Each pixel blends the two tints, weighted by its luma, as per the
+// zones of SelectiveSaturation (with the midtones taking half of each tint).
+// balance, in [-1,1], moves the pivot between the shadows and highlights: 0 pivots at mid gray,
+// positive values move the pivot towards black (so more of the image takes the highlight tint),
+// and negative values move it towards white.
+// (A YCrCb or HSV image is toned in RGB, then converted back).
+// Modifies the current image.
+func (img *FloatImage) SplitTone(shadow, highlight [3]float32, balance float32) {
+	switch img.Space {
+	case YCrCb:
+		img.ToRGB()
+		defer img.ToYCrCb()
+	case HSV:
+		img.ToRGB()
+		defer img.ToHSV()
+	}
+
+	// the luma at which the two tints are equally weighted, kept strictly within the range
+	pivot := MID_GRAY * (1 - balance)
+	if pivot < 1 {
+		pivot = 1
+	} else if pivot > INTENSITY_MAX-1 {
+		pivot = INTENSITY_MAX - 1
+	}
+
+	// only the chroma of the tints is added
+	shadowLuma, highlightLuma := luminance(shadow[0], shadow[1], shadow[2]), luminance(highlight[0], highlight[1], highlight[2])
+	var shadowChroma, highlightChroma [3]float32
+	for i := 0; i < 3; i++ {
+		shadowChroma[i], highlightChroma[i] = shadow[i]-shadowLuma, highlight[i]-highlightLuma
+	}
+
+	img.convertPixels(func(r, g, b float32) (float32, float32, float32) {
+		// map the luma so that the pivot lies at mid gray, then split into zones
+		luma := luminance(r, g, b)
+		if luma < pivot {
+			luma = MID_GRAY * luma / pivot
+		} else {
+			luma = MID_GRAY + (INTENSITY_MAX-MID_GRAY)*(luma-pivot)/(INTENSITY_MAX-pivot)
+		}
+		inShadow, mid, inHighlight := lumaZones(luma)
+		ws, wh := inShadow+mid/2, inHighlight+mid/2
+
+		var out [3]float32
+		for i, v := range [3]float32{r, g, b} {
+			out[i] = clampIntensity(v + ws*shadowChroma[i] + wh*highlightChroma[i])
+		}
+		return out[0], out[1], out[2]
+	})
+}
+
+// Apply a split tone as per FloatImage.SplitTone, except return a new image
+// rather than modifying the original image.
+func SplitTone(img *FloatImage, shadow, highlight [3]float32, balance float32) *FloatImage {
+	result := img.Clone() // init new image
+	result.SplitTone(shadow, highlight, balance)
+	return result
+}
+
+// Render a signed image (e.g. the response of an edge detector) for viewing,
+// using a diverging colormap: positive values are shown in red, negative values in blue,
+// and zero as black. Without this, negative values would simply be clipped to black on output.
+// Each pixel's signed value is its luminance, multiplied by scale (and clipped to the [0,65535] range).
+// (The luminance is taken from the RGB view of the pixels, whatever the color space of the image).
+// Returns a new (RGB) image (the original is not modified).
+func (img *FloatImage) RenderSigned(scale float64) *FloatImage {
+	res := NewFloatImage(img.Width, img.Height)
+	for i := range res.Ip[0] {
+		v := float64(luminance(img.rgbAt(i))) * scale
+		if v > 0 {
+			res.Ip[0][i] = float32(math.Min(v, float64(INTENSITY_MAX)))
+		} else {
+			res.Ip[2][i] = float32(math.Min(-v, float64(INTENSITY_MAX)))
+		}
+	}
+	return res
+}
+
+// Count the distinct colors in the image, after quantizing to 8 bits per channel (as output by At).
+// Useful for deciding whether an image suits a paletted format (e.g. GIF).
+// To bound memory use, counting stops once more than maxCount colors are found:
+// in that case, (maxCount, false) is returned. Otherwise, exact is true.
+func (img *FloatImage) UniqueColors(maxCount int) (count int, exact bool) {
+	seen := make(map[uint32]bool)
+	for i := range img.Ip[0] {
+		r, g, b := img.rgbAt(i)
+		key := uint32(toUint8(r))<<16 | uint32(toUint8(g))<<8 | uint32(toUint8(b))
+		if !seen[key] {
+			if len(seen) == maxCount {
+				return maxCount, false
+			}
+			seen[key] = true
+		}
+	}
+	return len(seen), true
+}
@@ -0,0 +1,447 @@
+// Test file for color.go
+
+package imgproc
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// build an image filled with a single colour
+func solidImage(width, height int, r, g, b float32) *FloatImage {
+	img := NewFloatImage(width, height)
+	for i := range img.Ip[0] {
+		img.Ip[0][i], img.Ip[1][i], img.Ip[2][i] = r, g, b
+	}
+	return img
+}
+
+func TestGrayscaleOfPureRed(t *testing.T) {
+	img := solidImage(2, 2, 60000, 0, 0)
+	res := Grayscale(img)
+
+	exp := float32(0.299 * 60000)
+	for i := 0; i < 3; i++ {
+		for _, v := range res.Ip[i] {
+			assertFloat32Equals(t, exp, v, "Grayscale[red]")
+		}
+	}
+	assertFloat32Equals(t, 60000, img.Ip[0][0], "Grayscale.original")
+}
+
+func TestGrayscaleOfGrayIsUnchanged(t *testing.T) {
+	img := sequentialImage(4, 2)
+	res := Grayscale(img)
+	for i := 0; i < 3; i++ {
+		assertFloat32SliceEquals(t, img.Ip[i], res.Ip[i], "Grayscale[gray]")
+	}
+}
+
+func TestSwizzleSwapsRedAndBlue(t *testing.T) {
+	img := solidImage(2, 2, 100, 200, 300)
+	if err := img.Swizzle([3]int{2, 1, 0}); err != nil {
+		t.Fatal(err)
+	}
+	assertFloat32SliceEquals(t, []float32{300, 300, 300, 300}, img.Ip[0], "Swizzle[2,1,0].red")
+	assertFloat32SliceEquals(t, []float32{200, 200, 200, 200}, img.Ip[1], "Swizzle[2,1,0].green")
+	assertFloat32SliceEquals(t, []float32{100, 100, 100, 100}, img.Ip[2], "Swizzle[2,1,0].blue")
+}
+
+func TestSwizzleIdentityIsUnchanged(t *testing.T) {
+	img := sequentialImage(3, 2)
+	orig := img.Clone()
+	if err := img.Swizzle([3]int{0, 1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		assertFloat32SliceEquals(t, orig.Ip[i], img.Ip[i], "Swizzle[0,1,2]")
+	}
+}
+
+func TestSwizzleRejectsNonPermutations(t *testing.T) {
+	for _, order := range [][3]int{{0, 0, 1}, {0, 1, 3}, {-1, 1, 2}} {
+		img := solidImage(1, 1, 100, 200, 300)
+		assert(t, img.Swizzle(order) != nil, fmt.Sprintf("Swizzle should reject %v", order))
+		assertFloat32Equals(t, 100, img.Ip[0][0], "Swizzle[rejected].red")
+	}
+}
+
+// the saturation of an RGB pixel: its distance from the gray of the same luma, in the red plane
+func redChroma(img *FloatImage, i int) float32 {
+	return img.Ip[0][i] - luminance(img.rgbAt(i))
+}
+
+func TestSelectiveSaturationKeysOnLuma(t *testing.T) {
+	img := NewFloatImage(3, 1)
+	img.Set(0, 0, color.RGBA64{6000, 2000, 2000, 0xffff})    // dark red
+	img.Set(1, 0, color.RGBA64{36000, 31000, 31000, 0xffff}) // mid red
+	img.Set(2, 0, color.RGBA64{65000, 63000, 63000, 0xffff}) // bright red
+	res := SelectiveSaturation(img, 2, 1, 0.25)
+
+	for _, c := range []struct {
+		index int
+		exp   float32
+		title string
+	}{{0, 2, "shadow"}, {1, 1, "midtone"}, {2, 0.25, "highlight"}} {
+		ratio := redChroma(res, c.index) / redChroma(img, c.index)
+		assert(t, math.Abs(float64(ratio-c.exp)) < 0.1*float64(c.exp),
+			fmt.Sprintf("SelectiveSaturation[%s]: exp multiplier=%f, act=%f", c.title, c.exp, ratio))
+	}
+}
+
+func TestSelectiveSaturationOfGrayIsUnchanged(t *testing.T) {
+	img := grayImage(4, 1, 0, 10000, 40000, INTENSITY_MAX)
+	res := SelectiveSaturation(img, 0, 3, 2)
+	for i := 0; i < 3; i++ {
+		assertPlanesClose(t, img.Ip[i], res.Ip[i], 0.5, "SelectiveSaturation[gray]")
+	}
+}
+
+// teal and orange tints, for split toning
+var (
+	tealTint   = [3]float32{28000, 34000, 38000}
+	orangeTint = [3]float32{40000, 32000, 24000}
+)
+
+func TestSplitToneWarmsHighlightsAndCoolsShadows(t *testing.T) {
+	img := grayImage(5, 1, 5000, 15000, MID_GRAY, 50000, 58000)
+	res := SplitTone(img, tealTint, orangeTint, 0)
+
+	for x := 0; x < 2; x++ {
+		assert(t, res.Ip[2][x] > res.Ip[0][x], fmt.Sprintf("SplitTone[%d]: shadows should be cool: red=%f, blue=%f", x, res.Ip[0][x], res.Ip[2][x]))
+	}
+	for x := 3; x < 5; x++ {
+		assert(t, res.Ip[0][x] > res.Ip[2][x], fmt.Sprintf("SplitTone[%d]: highlights should be warm: red=%f, blue=%f", x, res.Ip[0][x], res.Ip[2][x]))
+	}
+
+	// only the chroma of the tints is added, so the luma is (nearly) unchanged
+	for x := 0; x < 5; x++ {
+		before, after := luminance(img.rgbAt(x)), luminance(res.rgbAt(x))
+		assert(t, math.Abs(float64(after-before)) < 1, fmt.Sprintf("SplitTone[%d]: luma changed from %f to %f", x, before, after))
+	}
+}
+
+func TestSplitToneBalanceMovesThePivot(t *testing.T) {
+	img := grayImage(1, 1, MID_GRAY)
+	warm := SplitTone(img, tealTint, orangeTint, 0.5)
+	cool := SplitTone(img, tealTint, orangeTint, -0.5)
+	assert(t, warm.Ip[0][0] > warm.Ip[2][0], "SplitTone[balance=0.5]: mid gray should take the highlight tint")
+	assert(t, cool.Ip[2][0] > cool.Ip[0][0], "SplitTone[balance=-0.5]: mid gray should take the shadow tint")
+}
+
+func TestGrayscaleLinearOfPureRed(t *testing.T) {
+	// linear luminance of sRGB red is 0.2126, which re-encodes to ~0.4984 in sRGB
+	img := solidImage(2, 2, INTENSITY_MAX, 0, 0)
+	res := GrayscaleLinear(img)
+	exp := []float32{0.4984 * INTENSITY_MAX, 0.4984 * INTENSITY_MAX, 0.4984 * INTENSITY_MAX, 0.4984 * INTENSITY_MAX}
+	for i := 0; i < 3; i++ {
+		assertPlanesClose(t, exp, res.Ip[i], 10, "GrayscaleLinear[red]")
+	}
+
+	// much lighter than the gamma-space luma (0.299)
+	naive := Grayscale(img)
+	assert(t, res.Ip[0][0] > naive.Ip[0][0]+0.15*INTENSITY_MAX,
+		fmt.Sprintf("GrayscaleLinear should differ from Grayscale on red: linear=%f, naive=%f", res.Ip[0][0], naive.Ip[0][0]))
+}
+
+func TestGrayscaleLinearOfGrayIsUnchanged(t *testing.T) {
+	img := grayImage(4, 1, 0, 1000, 30000, INTENSITY_MAX)
+	res := GrayscaleLinear(img)
+	for i := 0; i < 3; i++ {
+		assertPlanesClose(t, img.Ip[i], res.Ip[i], 1, "GrayscaleLinear[gray]")
+	}
+}
+
+func TestRenderSignedShowsBothPolaritiesOfLaplacian(t *testing.T) {
+	// vertical step edge: dark on the left, bright on the right
+	img := grayImage(6, 1, 0, 0, 0, 30000, 30000, 30000)
+	laplacian := img.ConvolveClamp(LaplaceWithoutDiagonal())
+	res := laplacian.RenderSigned(1)
+
+	// the dark side of the edge has a positive response (red), and the bright side a negative one (blue)
+	assert(t, res.Ip[0][2] > 0 && res.Ip[2][2] == 0, "RenderSigned: dark side of the edge should be red")
+	assert(t, res.Ip[2][3] > 0 && res.Ip[0][3] == 0, "RenderSigned: bright side of the edge should be blue")
+
+	// flat regions have no response, so are black
+	for _, x := range []int{0, 5} {
+		assert(t, res.Ip[0][x] == 0 && res.Ip[1][x] == 0 && res.Ip[2][x] == 0, "RenderSigned: flat region should be black")
+	}
+}
+
+func TestRenderSignedScalesAndClips(t *testing.T) {
+	img := grayImage(2, 1, 100, -50000)
+	res := img.RenderSigned(2)
+	assertFloat32Equals(t, 200, res.Ip[0][0], "RenderSigned[scaled]")
+	assertFloat32Equals(t, INTENSITY_MAX, res.Ip[2][1], "RenderSigned[clipped]")
+}
+
+func TestRenderSignedOfYCrCbImageMatchesRGB(t *testing.T) {
+	img := grayImage(3, 1, 100, -5000, 0)
+	img.Ip[2][0] = 40000 // add some color
+	exp := img.RenderSigned(1)
+
+	img.ToYCrCb()
+	act := img.RenderSigned(1)
+	for i := 0; i < 3; i++ {
+		assertPlanesClose(t, exp.Ip[i], act.Ip[i], 0.5, fmt.Sprintf("RenderSigned[YCrCb][plane %d]", i))
+	}
+}
+
+// a few representative colors: black, white, primaries, and an arbitrary mix
+var testColors = [][3]float32{
+	{0, 0, 0}, {65535, 65535, 65535}, {65535, 0, 0}, {0, 65535, 0}, {0, 0, 65535}, {12000, 40000, 25000},
+}
+
+// build a 1-pixel-high image, with one pixel of each of the test colors
+func testColorsImage() *FloatImage {
+	img := NewFloatImage(len(testColors), 1)
+	for i, c := range testColors {
+		img.Ip[0][i], img.Ip[1][i], img.Ip[2][i] = c[0], c[1], c[2]
+	}
+	return img
+}
+
+// compare two planes, with a tolerance suited to round trips through a color conversion
+func assertPlanesClose(t *testing.T, exp, act []float32, tolerance float64, title string) {
+	for i := range exp {
+		if math.Abs(float64(exp[i]-act[i])) > tolerance {
+			t.Errorf("%s[index=%d]: exp=%f, act=%f", title, i, exp[i], act[i])
+		}
+	}
+}
+
+func TestYCrCbRoundTrip(t *testing.T) {
+	orig := testColorsImage()
+	img := orig.Clone()
+
+	img.ToYCrCb()
+	assert(t, img.Space == YCrCb, "ToYCrCb should set the color space")
+	img.ToRGB()
+	assert(t, img.Space == RGB, "ToRGB should set the color space")
+
+	for i := 0; i < 3; i++ {
+		assertPlanesClose(t, orig.Ip[i], img.Ip[i], 0.5, "YCrCb round trip")
+	}
+}
+
+func TestYCrCbOfGrayHasNeutralChroma(t *testing.T) {
+	img := grayImage(2, 1, 1000, 50000)
+	img.ToYCrCb()
+	assertPlanesClose(t, []float32{1000, 50000}, img.Ip[0], 0.5, "ToYCrCb[gray].Y")
+	assertPlanesClose(t, []float32{CHROMA_OFFSET, CHROMA_OFFSET}, img.Ip[1], 0.5, "ToYCrCb[gray].Cr")
+	assertPlanesClose(t, []float32{CHROMA_OFFSET, CHROMA_OFFSET}, img.Ip[2], 0.5, "ToYCrCb[gray].Cb")
+}
+
+func TestAtOutputsRGBForYCrCbImage(t *testing.T) {
+	orig := testColorsImage()
+	img := orig.Clone()
+	img.ToYCrCb()
+
+	for x := range testColors {
+		exp, act := orig.At(x, 0).(color.RGBA), img.At(x, 0).(color.RGBA)
+		for _, d := range []int{int(exp.R) - int(act.R), int(exp.G) - int(act.G), int(exp.B) - int(act.B)} {
+			assert(t, d >= -1 && d <= 1, fmt.Sprintf("At[YCrCb](%d,0): exp=%v, act=%v", x, exp, act))
+		}
+	}
+}
+
+func TestToYCrCbTwiceIsNoOp(t *testing.T) {
+	img := testColorsImage()
+	img.ToYCrCb()
+	once := img.Clone()
+	img.ToYCrCb()
+	assertFloat32SliceEquals(t, once.Ip[1], img.Ip[1], "ToYCrCb[twice]")
+}
+
+func TestGrayscaleOfYCrCbImageMatchesRGB(t *testing.T) {
+	img := testColorsImage()
+	exp := Grayscale(img)
+
+	img.ToYCrCb()
+	img.Grayscale()
+	img.ToRGB()
+	for i := 0; i < 3; i++ {
+		assertPlanesClose(t, exp.Ip[i], img.Ip[i], 0.5, "Grayscale[YCrCb]")
+	}
+}
+
+func TestHSVRoundTrip(t *testing.T) {
+	orig := testColorsImage()
+	img := orig.Clone()
+
+	img.ToHSV()
+	assert(t, img.Space == HSV, "ToHSV should set the color space")
+	img.ToRGB()
+	for i := 0; i < 3; i++ {
+		assertPlanesClose(t, orig.Ip[i], img.Ip[i], 0.5, "HSV round trip")
+	}
+}
+
+func TestHSVOfKnownColors(t *testing.T) {
+	img := solidImage(1, 1, 65535, 0, 0) // pure red
+	img.ToHSV()
+	assertPlanesClose(t, []float32{0, 1, 1}, []float32{img.Ip[0][0], img.Ip[1][0], img.Ip[2][0]}, 1e-4, "ToHSV[red]")
+
+	img = solidImage(1, 1, 0, 0, 65535) // pure blue
+	img.ToHSV()
+	assertPlanesClose(t, []float32{240, 1, 1}, []float32{img.Ip[0][0], img.Ip[1][0], img.Ip[2][0]}, 1e-4, "ToHSV[blue]")
+
+	img = solidImage(1, 1, 32768, 32768, 32768) // achromatic gray: hue set to 0
+	img.ToHSV()
+	assertPlanesClose(t, []float32{0, 0, 0.5}, []float32{img.Ip[0][0], img.Ip[1][0], img.Ip[2][0]}, 1e-4, "ToHSV[gray]")
+}
+
+func TestHSVSaturationChangeKeepsHue(t *testing.T) {
+	img := testColorsImage()
+	img.ToHSV()
+	origHue, origSat := append([]float32(nil), img.Ip[0]...), append([]float32(nil), img.Ip[1]...)
+
+	// halve the saturation, then convert back and forth
+	for i := range img.Ip[1] {
+		img.Ip[1][i] /= 2
+	}
+	img.ToRGB()
+	img.ToHSV()
+
+	for i := range origHue {
+		if origSat[i] == 0 {
+			continue // hue is undefined
+		}
+		assertPlanesClose(t, origHue[i:i+1], img.Ip[0][i:i+1], 0.01, "HSV[desaturated].H")
+		assertPlanesClose(t, []float32{origSat[i] / 2}, img.Ip[1][i:i+1], 1e-4, "HSV[desaturated].S")
+	}
+}
+
+func TestYCrCbFromHSV(t *testing.T) {
+	orig := testColorsImage()
+	img := orig.Clone()
+	img.ToHSV()
+	img.ToYCrCb()
+
+	exp := orig.Clone()
+	exp.ToYCrCb()
+	for i := 0; i < 3; i++ {
+		assertPlanesClose(t, exp.Ip[i], img.Ip[i], 0.5, "HSV->YCrCb")
+	}
+}
+
+func TestUniqueColorsOfTwoColorImage(t *testing.T) {
+	img := checkerboardImage(6, 6, 2)
+	count, exact := img.UniqueColors(16)
+	assertIntEquals(t, 2, count, "UniqueColors[two-color].count")
+	assert(t, exact, "UniqueColors[two-color] should be exact")
+}
+
+func TestUniqueColorsIgnoresSubQuantumDifferences(t *testing.T) {
+	// all rounding to the same 8-bit level
+	img := grayImage(3, 1, 257*10-128, 257*10, 257*10+128)
+	count, exact := img.UniqueColors(16)
+	assertIntEquals(t, 1, count, "UniqueColors[same-level].count")
+	assert(t, exact, "UniqueColors[same-level] should be exact")
+}
+
+func TestUniqueColorsOfGradientIsCapped(t *testing.T) {
+	img := NewFloatImage(256, 1)
+	for x := 0; x < 256; x++ {
+		img.Ip[0][x] = float32(x) * float32(SCALE_CONST)
+	}
+	count, exact := img.UniqueColors(100)
+	assertIntEquals(t, 100, count, "UniqueColors[gradient].count")
+	assert(t, !exact, "UniqueColors[gradient] should not be exact")
+}
+
+func TestSepiaOfKnownColor(t *testing.T) {
+	img := solidImage(2, 1, 20000, 10000, 5000)
+	res := Sepia(img)
+
+	// brown: red > green > blue
+	exp := []float32{
+		0.393*20000 + 0.769*10000 + 0.189*5000,
+		0.349*20000 + 0.686*10000 + 0.168*5000,
+		0.272*20000 + 0.534*10000 + 0.131*5000,
+	}
+	for i := 0; i < 3; i++ {
+		assertPlanesClose(t, []float32{exp[i], exp[i]}, res.Ip[i], 0.01, fmt.Sprintf("Sepia[plane %d]", i))
+	}
+	assertFloat32Equals(t, 20000, img.Ip[0][0], "Sepia.original")
+}
+
+func TestSepiaClampsBrightColors(t *testing.T) {
+	img := solidImage(1, 1, 65535, 65535, 65535)
+	img.Sepia()
+	assertFloat32Equals(t, 65535, img.Ip[0][0], "Sepia[white].R")
+	assertFloat32Equals(t, 65535, img.Ip[1][0], "Sepia[white].G")
+	assert(t, img.Ip[2][0] < 65535, "Sepia[white].B should be below white")
+}
+
+// sharpen only the luma of the image, as per SharpenLaplace: via YCrCb, convolving one plane instead of three.
+func sharpenLaplaceLuma(img *FloatImage) {
+	img.ToYCrCb()
+	laplacian := *convolvePlane(&img.Ip[0], LaplaceSpherical(), img.Width, img.Height, clampPlaneExtension, nil)
+	for i, v := range laplacian {
+		img.Ip[0][i] += v
+	}
+	img.ToRGB()
+	img.Clamp()
+}
+
+func TestLumaOnlyPathsMatchRGBOnGrayscale(t *testing.T) {
+	img := Grayscale(checkerboardImage(16, 16, 3))
+	img.Contrast(0.5) // keep the sharpened values well within range
+
+	rgb, luma := SharpenLaplace(img), img.Clone()
+	sharpenLaplaceLuma(luma)
+	for i := 0; i < 3; i++ {
+		assertPlanesClose(t, rgb.Ip[i], luma.Ip[i], 0.5, fmt.Sprintf("SharpenLaplace[luma vs rgb][plane %d]", i))
+	}
+
+	rgb, luma = EqualizeHistogram(img), EqualizeLuma(img)
+	for i := 0; i < 3; i++ {
+		assertPlanesClose(t, rgb.Ip[i], luma.Ip[i], 0.5, fmt.Sprintf("Equalize[luma vs rgb][plane %d]", i))
+	}
+}
+
+// a colored image for comparing the luma-only and RGB benchmarks.
+// Each iteration works on a fresh copy of the image, as the operations modify it,
+// and the luma-only benchmarks include the cost of converting to YCrCb and back.
+func benchmarkImage() *FloatImage {
+	img := checkerboardImage(256, 256, 8)
+	for i := range img.Ip[1] {
+		img.Ip[1][i] *= 0.5 // add some color
+	}
+	return img
+}
+
+func BenchmarkSharpenLaplaceRGB(b *testing.B) {
+	img := benchmarkImage()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		img.Clone().SharpenLaplace()
+	}
+}
+
+func BenchmarkSharpenLaplaceLuma(b *testing.B) {
+	img := benchmarkImage()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		sharpenLaplaceLuma(img.Clone())
+	}
+}
+
+func BenchmarkEqualizeHistogramRGB(b *testing.B) {
+	img := benchmarkImage()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		img.Clone().EqualizeHistogram()
+	}
+}
+
+func BenchmarkEqualizeLuma(b *testing.B) {
+	img := benchmarkImage()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		img.Clone().EqualizeLuma()
+	}
+}
@@ -0,0 +1,211 @@
+// Implements non-linear filters for noise removal.
+package imgproc
+
+import (
+	"math"
+	"sort"
+)
+
+// helper function for the filters which return a new image: filter a YCrCb or HSV image
+// in RGB, then convert the result back into the original color space.
+func filterInRGB(img *FloatImage, filter func(rgb *FloatImage) *FloatImage) *FloatImage {
+	rgb := img.Clone()
+	rgb.ToRGB()
+	res := filter(rgb)
+	switch img.Space {
+	case YCrCb:
+		res.ToYCrCb()
+	case HSV:
+		res.ToHSV()
+	}
+	return res
+}
+
+// helper function for median filtering a single intensity plane, into dst.
+func medianPlane(src, dst []float32, width, height, radius int) {
+	diameter := 2*radius + 1
+	window := make([]float32, diameter*diameter)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			// collect the neighbourhood (clamped at the edges), then pick its middle value
+			n := 0
+			for yk := -radius; yk <= radius; yk++ {
+				row := clampPlaneExtension(y+yk, height) * width
+				for xk := -radius; xk <= radius; xk++ {
+					window[n] = src[row+clampPlaneExtension(x+xk, width)]
+					n++
+				}
+			}
+			sort.Slice(window, func(a, b int) bool { return window[a] < window[b] })
+			dst[y*width+x] = window[len(window)/2] // the window always has an odd size
+		}
+	}
+}
+
+// MedianFilter replaces each pixel with the median of its (2*radius+1) x (2*radius+1)
+// neighbourhood, independently in each plane, with clamping at the edges.
+// Unlike a (linear) blur, this removes impulse ("salt and pepper") noise while preserving edges.
+// A radius <= 0 leaves the image unchanged.
+// (A YCrCb or HSV image is filtered in RGB, then converted back).
+// Returns a new image (the original is not modified).
+func (img *FloatImage) MedianFilter(radius int) *FloatImage {
+	if radius <= 0 {
+		return img.Clone()
+	}
+	if img.Space != RGB {
+		return filterInRGB(img, func(rgb *FloatImage) *FloatImage { return rgb.MedianFilter(radius) })
+	}
+
+	res := NewFloatImage(img.Width, img.Height)
+	res.Space = img.Space
+	for i := 0; i < 3; i++ {
+		medianPlane(img.Ip[i], res.Ip[i], img.Width, img.Height, radius)
+	}
+	return res
+}
+
+// helper function for correcting the hot pixels of a single intensity plane, in place.
+func fixHotPixelsPlane(plane []float32, width, height int, threshold float32) {
+	src := make([]float32, len(plane)) // the original values, since plane is corrected as we go
+	copy(src, plane)
+
+	neighbours := make([]float32, 0, 8)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			// collect the neighbours within the image (i.e. 3 at the corners, 5 along the edges, otherwise 8)
+			neighbours = neighbours[:0]
+			for yk := y - 1; yk <= y+1; yk++ {
+				for xk := x - 1; xk <= x+1; xk++ {
+					if (xk != x || yk != y) && xk >= 0 && xk < width && yk >= 0 && yk < height {
+						neighbours = append(neighbours, src[yk*width+xk])
+					}
+				}
+			}
+			if len(neighbours) == 0 {
+				continue // a 1x1 image has nothing to compare against
+			}
+
+			sort.Slice(neighbours, func(a, b int) bool { return neighbours[a] < neighbours[b] })
+			n := len(neighbours)
+			median := neighbours[n/2]
+			if n%2 == 0 {
+				median = (neighbours[n/2-1] + median) / 2
+			}
+
+			index := y*width + x
+			if diff := src[index] - median; diff > threshold || diff < -threshold {
+				plane[index] = median
+			}
+		}
+	}
+}
+
+// FixHotPixels corrects isolated defective ("hot" or "stuck") pixels, e.g. from a sensor defect:
+// each pixel differing from the median of its 8 neighbours by more than threshold is replaced
+// by that median, independently in each plane. Unlike MedianFilter, all other pixels are left
+// untouched, so fine detail is kept (provided the threshold is above the local contrast of the detail).
+// At the edges of the image, only the neighbours within the image are used.
+// (A YCrCb or HSV image is corrected in RGB, then converted back).
+// Modifies the current image.
+func (img *FloatImage) FixHotPixels(threshold float32) {
+	switch img.Space {
+	case YCrCb:
+		img.ToRGB()
+		defer img.ToYCrCb()
+	case HSV:
+		img.ToRGB()
+		defer img.ToHSV()
+	}
+
+	for i := 0; i < 3; i++ {
+		fixHotPixelsPlane(img.Ip[i], img.Width, img.Height, threshold)
+	}
+}
+
+// EstimateNoise estimates the standard deviation of the (Gaussian) noise in the image, from its luma.
+// The luma is high-pass filtered with a kernel which cancels out smooth regions (and, mostly, edges),
+// leaving a response dominated by the noise. For independent noise with standard deviation sigma,
+// the response has standard deviation 6*sigma, which is estimated robustly from the
+// median absolute response (MAD), so the remaining edges have little influence.
+// Returns the estimate, in the [0,65536) scale.
+func (img *FloatImage) EstimateNoise() float64 {
+	if img.Width < 3 || img.Height < 3 {
+		return 0 // too small to filter
+	}
+
+	luma := make([]float32, img.Width*img.Height)
+	for i := range luma {
+		luma[i] = luminance(img.rgbAt(i))
+	}
+	highPass := NewConvKernel3(1, -2, 1, -2, 4, -2, 1, -2, 1)
+	response := *convolvePlane(&luma, highPass, img.Width, img.Height, clampPlaneExtension, nil)
+
+	// only the interior pixels: the clamped edges would under-estimate the noise
+	abs := make([]float64, 0, (img.Width-2)*(img.Height-2))
+	for y := 1; y < img.Height-1; y++ {
+		for x := 1; x < img.Width-1; x++ {
+			abs = append(abs, math.Abs(float64(response[y*img.Width+x])))
+		}
+	}
+	sort.Float64s(abs)
+
+	// for a normal distribution, the standard deviation is MAD / 0.6745
+	return abs[len(abs)/2] / 0.6745 / 6
+}
+
+// helper function for bilateral filtering a single intensity plane, into dst.
+func bilateralPlane(src, dst []float32, width, height, radius int, spatial []float64, rangeAlpha float64) {
+	diameter := 2*radius + 1
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			center := float64(src[y*width+x])
+			sum, totalWeight := float64(0), float64(0)
+			for yk := -radius; yk <= radius; yk++ {
+				row := clampPlaneExtension(y+yk, height) * width
+				for xk := -radius; xk <= radius; xk++ {
+					v := float64(src[row+clampPlaneExtension(x+xk, width)])
+					diff := v - center
+					weight := spatial[(yk+radius)*diameter+xk+radius] * math.Exp(-rangeAlpha*diff*diff)
+					sum += weight * v
+					totalWeight += weight
+				}
+			}
+			dst[y*width+x] = float32(sum / totalWeight) // the center always has a positive weight
+		}
+	}
+}
+
+// BilateralFilter smooths the image while preserving edges: each pixel is replaced by a weighted
+// average of its neighbourhood, where the weights fall off (as Gaussians) with both the distance
+// from the pixel (standard deviation sigmaSpatial, in pixels) and the difference in intensity
+// from the pixel (standard deviation sigmaRange, in the [0,65536) scale).
+// Neighbours across an edge differ greatly in intensity, so contribute little.
+// Each plane is filtered independently, with clamping at the edges.
+// A sigmaSpatial or sigmaRange <= 0 leaves the image unchanged.
+// (A YCrCb or HSV image is filtered in RGB, then converted back).
+// Returns a new image (the original is not modified).
+func (img *FloatImage) BilateralFilter(sigmaSpatial, sigmaRange float64) *FloatImage {
+	if sigmaSpatial <= 0 || sigmaRange <= 0 {
+		return img.Clone()
+	}
+	if img.Space != RGB {
+		return filterInRGB(img, func(rgb *FloatImage) *FloatImage { return rgb.BilateralFilter(sigmaSpatial, sigmaRange) })
+	}
+
+	// the spatial weights, out to 2 standard deviations
+	radius := int(math.Ceil(2 * sigmaSpatial))
+	diameter := 2*radius + 1
+	spatial := make([]float64, diameter*diameter)
+	for yk := -radius; yk <= radius; yk++ {
+		for xk := -radius; xk <= radius; xk++ {
+			spatial[(yk+radius)*diameter+xk+radius] = math.Exp(-float64(xk*xk+yk*yk) / (2 * sigmaSpatial * sigmaSpatial))
+		}
+	}
+
+	res := NewFloatImage(img.Width, img.Height)
+	res.Space = img.Space
+	for i := 0; i < 3; i++ {
+		bilateralPlane(img.Ip[i], res.Ip[i], img.Width, img.Height, radius, spatial, 0.5/(sigmaRange*sigmaRange))
+	}
+	return res
+}
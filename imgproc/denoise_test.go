@@ -0,0 +1,150 @@
+// Test file for denoise.go
+
+package imgproc
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestMedianFilterRemovesSaltNoise(t *testing.T) {
+	img := solidImage(5, 5, 1000, 2000, 3000)
+	img.Ip[0][12], img.Ip[1][12], img.Ip[2][12] = 65535, 65535, 65535 // a single bright pixel, in the center
+
+	res := img.MedianFilter(1)
+	exp := solidImage(5, 5, 1000, 2000, 3000)
+	for i := 0; i < 3; i++ {
+		assertFloat32SliceEquals(t, exp.Ip[i], res.Ip[i], "MedianFilter[salt]")
+	}
+	assertFloat32Equals(t, 65535, img.Ip[0][12], "MedianFilter.original")
+}
+
+func TestMedianFilterPreservesEdges(t *testing.T) {
+	img := grayImage(6, 1, 0, 0, 0, 9, 9, 9)
+	res := img.MedianFilter(1)
+	assertFloat32SliceEquals(t, img.Ip[0], res.Ip[0], "MedianFilter[step]")
+}
+
+func TestMedianFilterOfRadiusZero(t *testing.T) {
+	img := sequentialImage(3, 3)
+	res := img.MedianFilter(0)
+	assertFloat32SliceEquals(t, img.Ip[1], res.Ip[1], "MedianFilter[r=0]")
+}
+
+func TestFixHotPixelsCorrectsOnlyDefects(t *testing.T) {
+	// a gentle ramp, with a sharp vertical step edge (legitimate detail) between columns 5 and 6
+	width, height := 10, 8
+	exp := NewFloatImage(width, height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := float32(1000*x + 500*y)
+			if x >= 6 {
+				v += 30000
+			}
+			for i := 0; i < 3; i++ {
+				exp.Ip[i][y*width+x] = v
+			}
+		}
+	}
+
+	// a few hot pixels: all planes in the interior and at a corner, and a single stuck red pixel
+	img := exp.Clone()
+	for _, index := range []int{2*width + 2, 5*width + 8, 0} {
+		for i := 0; i < 3; i++ {
+			img.Ip[i][index] = INTENSITY_MAX
+		}
+	}
+	img.Ip[0][6*width+3] = INTENSITY_MAX
+
+	img.FixHotPixels(10000)
+
+	// the hot pixels take the median of their neighbours, which (on a ramp) is their original value,
+	// except at the corner, where the median of the 3 neighbours is the middle one
+	exp.Ip[0][0], exp.Ip[1][0], exp.Ip[2][0] = 1000, 1000, 1000
+	for i := 0; i < 3; i++ {
+		assertPlanesClose(t, exp.Ip[i], img.Ip[i], 0.5, fmt.Sprintf("FixHotPixels[%d]", i))
+	}
+}
+
+func TestDenoiseOfNonRGBImageFiltersInRGB(t *testing.T) {
+	assertAdjustsInRGB(t, func(img *FloatImage) { *img = *img.MedianFilter(1) }, "MedianFilter")
+	assertAdjustsInRGB(t, func(img *FloatImage) { *img = *img.BilateralFilter(1, 20000) }, "BilateralFilter")
+	assertAdjustsInRGB(t, func(img *FloatImage) { img.FixHotPixels(1000) }, "FixHotPixels")
+}
+
+func TestFixHotPixelsOfTinyImages(t *testing.T) {
+	img := grayImage(1, 1, 65535)
+	img.FixHotPixels(1)
+	assertFloat32Equals(t, 65535, img.Ip[0][0], "FixHotPixels[1x1]")
+}
+
+// add independent Gaussian noise (with a fixed seed) to every pixel of the image.
+// The noise is gray, i.e. the same in all three planes, so the luma has the same noise level.
+func addNoise(img *FloatImage, sigma float64) {
+	rng := rand.New(rand.NewSource(1))
+	for j := range img.Ip[0] {
+		noise := float32(rng.NormFloat64() * sigma)
+		for i := 0; i < 3; i++ {
+			img.Ip[i][j] += noise
+		}
+	}
+}
+
+// variance of a plane, over columns [x0,x1)
+func columnVariance(plane []float32, width, height, x0, x1 int) float64 {
+	sum, sumSq, n := float64(0), float64(0), float64((x1-x0)*height)
+	for y := 0; y < height; y++ {
+		for x := x0; x < x1; x++ {
+			v := float64(plane[y*width+x])
+			sum, sumSq = sum+v, sumSq+v*v
+		}
+	}
+	mean := sum / n
+	return sumSq/n - mean*mean
+}
+
+func TestEstimateNoiseOfNoisyImage(t *testing.T) {
+	img := solidImage(64, 64, 30000, 30000, 30000)
+	addNoise(img, 1000)
+	est := img.EstimateNoise()
+	assert(t, math.Abs(est-1000) < 100, fmt.Sprintf("EstimateNoise: exp~1000, act=%f", est))
+}
+
+func TestEstimateNoiseIgnoresEdges(t *testing.T) {
+	est := checkerboardImage(64, 64, 8).EstimateNoise()
+	assert(t, est < 1, fmt.Sprintf("EstimateNoise[clean checkerboard]: exp~0, act=%f", est))
+}
+
+func TestBilateralFilterSmoothsButKeepsEdges(t *testing.T) {
+	width, height := 32, 16
+	img := grayImage(width, height)
+	for y := 0; y < height; y++ {
+		for x := width / 2; x < width; x++ {
+			for i := 0; i < 3; i++ {
+				img.Ip[i][y*width+x] = 40000 // a step edge, in the middle
+			}
+		}
+	}
+	addNoise(img, 500)
+
+	res := img.BilateralFilter(2, 1500)
+	before := columnVariance(img.Ip[0], width, height, 0, width/2)
+	after := columnVariance(res.Ip[0], width, height, 0, width/2)
+	assert(t, after*4 < before, fmt.Sprintf("BilateralFilter should smooth flat regions: before=%f, after=%f", before, after))
+
+	// the step is still sharp: the columns either side of it stay far apart
+	left := columnMean(res.Ip[0], width, height, width/2-1)
+	right := columnMean(res.Ip[0], width, height, width/2)
+	assert(t, right-left > 38000, fmt.Sprintf("BilateralFilter should keep the edge: left=%f, right=%f", left, right))
+}
+
+// mean of a single column of a plane
+func columnMean(plane []float32, width, height, x int) float64 {
+	sum := float64(0)
+	for y := 0; y < height; y++ {
+		sum += float64(plane[y*width+x])
+	}
+	return sum / float64(height)
+}
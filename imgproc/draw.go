@@ -0,0 +1,120 @@
+// Implements drawing primitives, for annotating images.
+// Colours are given as RGB intensities in the [0,65536) range, whatever the color space
+// of the image (they are converted into it).
+package imgproc
+
+// Set the pixel at (x,y) to the given colour.
+// Pixels outside the image are ignored, so shapes are clipped at the image edges.
+func (img *FloatImage) setRGB(x, y int, r, g, b float32) {
+	if x < 0 || x >= img.Width || y < 0 || y >= img.Height {
+		return
+	}
+	img.setRGBAt(y*img.Width+x, r, g, b)
+}
+
+// Draw gridlines every spacing pixels (starting from the top-left corner), in the given colour.
+// Useful for visually checking geometric operations, e.g. resizing or cropping.
+// Modifies the current image.
+func (img *FloatImage) DrawGrid(spacing int, r, g, b float32) {
+	if spacing < 1 {
+		return
+	}
+
+	for y := 0; y < img.Height; y++ {
+		for x := 0; x < img.Width; x++ {
+			if x%spacing == 0 || y%spacing == 0 {
+				img.setRGB(x, y, r, g, b)
+			}
+		}
+	}
+}
+
+// Draw a line from (x0,y0) to (x1,y1) inclusive, in the given colour,
+// using Bresenham's line algorithm.
+// Modifies the current image.
+func (img *FloatImage) DrawLine(x0, y0, x1, y1 int, r, g, b float32) {
+	dx, dy := x1-x0, y1-y0
+	stepX, stepY := 1, 1
+	if dx < 0 {
+		dx, stepX = -dx, -1
+	}
+	if dy < 0 {
+		dy, stepY = -dy, -1
+	}
+
+	// err tracks the (scaled) distance from the ideal line, for both directions at once
+	err := dx - dy
+	for {
+		img.setRGB(x0, y0, r, g, b)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * err
+		if e2 > -dy {
+			err -= dy
+			x0 += stepX
+		}
+		if e2 < dx {
+			err += dx
+			y0 += stepY
+		}
+	}
+}
+
+// Draw the outline of the rectangle with top-left corner (x,y), of width w and height h, in the given colour.
+// The outline lies inside the rectangle, i.e. covers columns x to x+w-1 and rows y to y+h-1.
+// Modifies the current image.
+func (img *FloatImage) DrawRect(x, y, w, h int, r, g, b float32) {
+	if w < 1 || h < 1 {
+		return
+	}
+	right, bottom := x+w-1, y+h-1
+	img.DrawLine(x, y, right, y, r, g, b)
+	img.DrawLine(x, bottom, right, bottom, r, g, b)
+	img.DrawLine(x, y, x, bottom, r, g, b)
+	img.DrawLine(right, y, right, bottom, r, g, b)
+}
+
+// Draw a circle of the given radius, centered on (cx,cy), in the given colour.
+// The outline is drawn using the midpoint circle algorithm; if filled is set,
+// the interior is also filled (one horizontal scanline at a time).
+// Modifies the current image.
+func (img *FloatImage) DrawCircle(cx, cy, radius int, r, g, b float32, filled bool) {
+	if radius < 0 {
+		return
+	}
+
+	// plot the points in all 8 octants, given one point (x,y) in the first octant
+	plot := func(x, y int) {
+		if filled {
+			// the rows at cy+-y and cy+-x are symmetric spans
+			img.DrawLine(cx-x, cy+y, cx+x, cy+y, r, g, b)
+			img.DrawLine(cx-x, cy-y, cx+x, cy-y, r, g, b)
+			img.DrawLine(cx-y, cy+x, cx+y, cy+x, r, g, b)
+			img.DrawLine(cx-y, cy-x, cx+y, cy-x, r, g, b)
+			return
+		}
+		img.setRGB(cx+x, cy+y, r, g, b)
+		img.setRGB(cx-x, cy+y, r, g, b)
+		img.setRGB(cx+x, cy-y, r, g, b)
+		img.setRGB(cx-x, cy-y, r, g, b)
+		img.setRGB(cx+y, cy+x, r, g, b)
+		img.setRGB(cx-y, cy+x, r, g, b)
+		img.setRGB(cx+y, cy-x, r, g, b)
+		img.setRGB(cx-y, cy-x, r, g, b)
+	}
+
+	// walk the first octant, from (radius,0) until x and y meet,
+	// using the decision variable d to choose whether to step x inwards.
+	x, y, d := radius, 0, 1-radius
+	for x >= y {
+		plot(x, y)
+		y++
+		if d < 0 {
+			d += 2*y + 1
+		} else {
+			x--
+			d += 2*(y-x) + 1
+		}
+	}
+}
@@ -0,0 +1,125 @@
+// Test file for draw.go
+
+package imgproc
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+// check whether the pixel at (x,y) has the given colour
+func hasColour(img *FloatImage, x, y int, r, g, b float32) bool {
+	i := y*img.Width + x
+	return img.Ip[0][i] == r && img.Ip[1][i] == g && img.Ip[2][i] == b
+}
+
+func TestDrawGrid(t *testing.T) {
+	orig := sequentialImage(7, 5)
+	img := orig.Clone()
+	img.DrawGrid(3, 100, 200, 300)
+
+	for y := 0; y < img.Height; y++ {
+		for x := 0; x < img.Width; x++ {
+			onGrid := x%3 == 0 || y%3 == 0
+			i := y*img.Width + x
+			if onGrid {
+				assert(t, hasColour(img, x, y, 100, 200, 300), fmt.Sprintf("DrawGrid: (%d,%d) should be a gridline", x, y))
+			} else {
+				assert(t, hasColour(img, x, y, orig.Ip[0][i], orig.Ip[1][i], orig.Ip[2][i]),
+					fmt.Sprintf("DrawGrid: (%d,%d) should be unchanged", x, y))
+			}
+		}
+	}
+}
+
+func TestDrawGridWithInvalidSpacingDoesNothing(t *testing.T) {
+	img := sequentialImage(3, 3)
+	img.DrawGrid(0, 1, 1, 1)
+	assertFloat32SliceEquals(t, sequentialImage(3, 3).Ip[0], img.Ip[0], "DrawGrid[spacing=0]")
+}
+
+func TestDrawHorizontalLine(t *testing.T) {
+	img := NewFloatImage(6, 4)
+	img.DrawLine(1, 2, 4, 2, 1, 2, 3)
+
+	for y := 0; y < img.Height; y++ {
+		for x := 0; x < img.Width; x++ {
+			onLine := y == 2 && x >= 1 && x <= 4
+			assert(t, hasColour(img, x, y, 1, 2, 3) == onLine, fmt.Sprintf("DrawLine[horizontal]: (%d,%d) on-line=%t", x, y, onLine))
+		}
+	}
+}
+
+func TestDrawDiagonalLineInEitherDirection(t *testing.T) {
+	forward, backward := NewFloatImage(4, 4), NewFloatImage(4, 4)
+	forward.DrawLine(0, 0, 3, 3, 1, 1, 1)
+	backward.DrawLine(3, 3, 0, 0, 1, 1, 1)
+
+	for d := 0; d < 4; d++ {
+		assert(t, hasColour(forward, d, d, 1, 1, 1), fmt.Sprintf("DrawLine[forward]: (%d,%d) should be set", d, d))
+	}
+	assertFloat32SliceEquals(t, forward.Ip[0], backward.Ip[0], "DrawLine[backward]")
+}
+
+func TestDrawLineIsClippedToImage(t *testing.T) {
+	img := NewFloatImage(3, 3)
+	img.DrawLine(-5, 1, 10, 1, 1, 1, 1) // must not panic
+	for x := 0; x < 3; x++ {
+		assert(t, hasColour(img, x, 1, 1, 1, 1), fmt.Sprintf("DrawLine[clipped]: (%d,1) should be set", x))
+	}
+}
+
+func TestDrawRectOutlinesBounds(t *testing.T) {
+	img := NewFloatImage(7, 6)
+	img.DrawRect(1, 1, 4, 3, 5, 5, 5)
+
+	for y := 0; y < img.Height; y++ {
+		for x := 0; x < img.Width; x++ {
+			inside := x >= 1 && x <= 4 && y >= 1 && y <= 3
+			onBorder := inside && (x == 1 || x == 4 || y == 1 || y == 3)
+			assert(t, hasColour(img, x, y, 5, 5, 5) == onBorder, fmt.Sprintf("DrawRect: (%d,%d) on-border=%t", x, y, onBorder))
+		}
+	}
+}
+
+func TestDrawCircleOutlineLiesAtRadius(t *testing.T) {
+	cx, cy, radius := 10, 9, 6
+	img := NewFloatImage(21, 19)
+	img.DrawCircle(cx, cy, radius, 1, 1, 1, false)
+
+	numSet := 0
+	for y := 0; y < img.Height; y++ {
+		for x := 0; x < img.Width; x++ {
+			if hasColour(img, x, y, 1, 1, 1) {
+				numSet++
+				dist := math.Hypot(float64(x-cx), float64(y-cy))
+				assert(t, math.Abs(dist-float64(radius)) < 1, fmt.Sprintf("DrawCircle: (%d,%d) is %f from the center", x, y, dist))
+			}
+		}
+	}
+	assert(t, numSet > 0, "DrawCircle should set some pixels")
+
+	// the four extreme points are on the outline, and the center is not
+	for _, p := range [][2]int{{cx + radius, cy}, {cx - radius, cy}, {cx, cy + radius}, {cx, cy - radius}} {
+		assert(t, hasColour(img, p[0], p[1], 1, 1, 1), fmt.Sprintf("DrawCircle: (%d,%d) should be set", p[0], p[1]))
+	}
+	assert(t, !hasColour(img, cx, cy, 1, 1, 1), "DrawCircle: outline should not set the center")
+}
+
+func TestDrawCircleFilled(t *testing.T) {
+	cx, cy, radius := 6, 6, 5
+	img := NewFloatImage(13, 13)
+	img.DrawCircle(cx, cy, radius, 1, 1, 1, true)
+
+	for y := 0; y < img.Height; y++ {
+		for x := 0; x < img.Width; x++ {
+			dist := math.Hypot(float64(x-cx), float64(y-cy))
+			if dist < float64(radius)-1 {
+				assert(t, hasColour(img, x, y, 1, 1, 1), fmt.Sprintf("DrawCircle[filled]: (%d,%d) should be filled", x, y))
+			} else if dist > float64(radius)+1 {
+				assert(t, !hasColour(img, x, y, 1, 1, 1), fmt.Sprintf("DrawCircle[filled]: (%d,%d) should be outside", x, y))
+			}
+		}
+	}
+}
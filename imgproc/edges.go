@@ -0,0 +1,92 @@
+// Implements edge detection.
+package imgproc
+
+import "math"
+
+// compute the gradient magnitude of each plane, sqrt(gx^2 + gy^2), where gx and gy
+// are the responses to the horizontal and vertical gradient kernels (with edge clamping).
+func (img *FloatImage) gradientMagnitude(kernelX, kernelY *ConvKernel) *FloatImage {
+	gx, gy := img.ConvolveClamp(kernelX), img.ConvolveClamp(kernelY)
+	res := NewFloatImage(img.Width, img.Height)
+	for i := 0; i < 3; i++ {
+		for j := range res.Ip[i] {
+			x, y := float64(gx.Ip[i][j]), float64(gy.Ip[i][j])
+			res.Ip[i][j] = float32(math.Sqrt(x*x + y*y))
+		}
+	}
+	return res
+}
+
+// SobelMagnitude computes the gradient magnitude of each plane, using the Sobel operator:
+// each pixel is sqrt(gx^2 + gy^2), where gx and gy are the responses to SobelX and SobelY.
+// Flat regions give zero, and edges give large values (up to about 4 times the step across the edge).
+// The edges of the image are clamped, so they don't produce a response themselves.
+// Returns a new image (the original is not modified).
+func (img *FloatImage) SobelMagnitude() *FloatImage {
+	return img.gradientMagnitude(SobelX(), SobelY())
+}
+
+// PrewittMagnitude computes the gradient magnitude of each plane, as per SobelMagnitude,
+// except using the Prewitt operator (PrewittX and PrewittY), which weights its three rows equally.
+// Edges give up to about 3 times the step across the edge.
+// Returns a new image (the original is not modified).
+func (img *FloatImage) PrewittMagnitude() *FloatImage {
+	return img.gradientMagnitude(PrewittX(), PrewittY())
+}
+
+// FocusPeaking highlights the in-focus (sharp) edges of the image, e.g. for reviewing manual focus:
+// pixels where the Sobel gradient magnitude of the luma exceeds threshold are overlaid in the given colour,
+// and the rest of the image is left intact. As per SobelMagnitude, a step edge gives a magnitude of
+// about 4 times the step, so e.g. a threshold of 20000 picks out steps of 5000 and more.
+// As with the drawing primitives, the colour is given as RGB intensities (whatever the color space of the image).
+// Returns a new image (the original is not modified).
+func (img *FloatImage) FocusPeaking(threshold float64, r, g, b float32) *FloatImage {
+	luma := make([]float32, img.Width*img.Height)
+	for i := range luma {
+		luma[i] = luminance(img.rgbAt(i))
+	}
+	gx := *convolvePlane(&luma, SobelX(), img.Width, img.Height, clampPlaneExtension, nil)
+	gy := *convolvePlane(&luma, SobelY(), img.Width, img.Height, clampPlaneExtension, nil)
+
+	res := img.Clone()
+	for y := 0; y < img.Height; y++ {
+		for x := 0; x < img.Width; x++ {
+			i := y*img.Width + x
+			if math.Hypot(float64(gx[i]), float64(gy[i])) > threshold {
+				res.setRGB(x, y, r, g, b)
+			}
+		}
+	}
+	return res
+}
+
+// Emboss the image, lit from the given direction (one of NW, NE, SW or SE: see EmbossKernel):
+// edges facing the light are brightened, and edges facing away are darkened.
+// The image is convolved with the emboss kernel, then offset by mid gray (so that flat regions
+// become mid gray), and clamped into [0,65535].
+// (A YCrCb or HSV image is embossed in RGB, then converted back).
+// Returns an error (leaving the image unchanged) if the direction is unknown.
+// Modifies the current image.
+func (img *FloatImage) Emboss(direction string) error {
+	kernel, err := EmbossKernel(direction)
+	if err != nil {
+		return err
+	}
+
+	switch img.Space {
+	case YCrCb:
+		img.ToRGB()
+		defer img.ToYCrCb()
+	case HSV:
+		img.ToRGB()
+		defer img.ToHSV()
+	}
+
+	img.ConvolveClampWith(kernel)
+	for i := 0; i < 3; i++ {
+		for j, v := range img.Ip[i] {
+			img.Ip[i][j] = clampIntensity(v + MID_GRAY)
+		}
+	}
+	return nil
+}
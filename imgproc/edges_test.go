@@ -0,0 +1,114 @@
+// Test file for edges.go
+
+package imgproc
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+// build a gray image with a vertical step edge: black on the left half, and the given level on the right.
+func verticalEdgeImage(width, height int, level float32) *FloatImage {
+	img := NewFloatImage(width, height)
+	for y := 0; y < height; y++ {
+		for x := width / 2; x < width; x++ {
+			for i := 0; i < 3; i++ {
+				img.Ip[i][y*width+x] = level
+			}
+		}
+	}
+	return img
+}
+
+func TestSobelRespondsToVerticalEdgeHorizontally(t *testing.T) {
+	img := verticalEdgeImage(8, 6, 1000)
+	gx, gy := img.ConvolveClamp(SobelX()), img.ConvolveClamp(SobelY())
+
+	// at the edge (between columns 3 and 4), gx is 4 times the step; gy is zero throughout
+	assertFloat32Equals(t, 4000, gx.Ip[0][2*8+3], "SobelX.edge")
+	assertFloat32Equals(t, 4000, gx.Ip[0][2*8+4], "SobelX.edge")
+	assertFloat32Equals(t, 0, gx.Ip[0][2*8+1], "SobelX.flat")
+	for j, v := range gy.Ip[0] {
+		assert(t, math.Abs(float64(v)) < 1e-3, fmt.Sprintf("SobelY[%d]: exp=0, act=%f", j, v))
+	}
+}
+
+func TestSobelMagnitudeOfVerticalEdge(t *testing.T) {
+	img := verticalEdgeImage(8, 6, 1000)
+	mag := img.SobelMagnitude()
+	for i := 0; i < 3; i++ {
+		assertFloat32SliceEquals(t, []float32{0, 0, 0, 4000, 4000, 0, 0, 0}, mag.Ip[i][3*8:4*8], fmt.Sprintf("SobelMagnitude[%d]", i))
+	}
+}
+
+func TestSobelMagnitudeCombinesBothDirections(t *testing.T) {
+	// a single bright pixel: gx = 2*v and gy = 0 to its left, so the magnitude is 2*v;
+	// diagonally, gx = gy = v, so the magnitude is sqrt(2)*v
+	img := grayImage(3, 3, 0, 0, 0, 0, 1000, 0, 0, 0, 0)
+	mag := img.SobelMagnitude()
+	assertFloat32Equals(t, 2000, mag.Ip[0][3], "SobelMagnitude.left")
+	assert(t, math.Abs(float64(mag.Ip[0][0])-1000*math.Sqrt2) < 1e-2, fmt.Sprintf("SobelMagnitude.corner: exp=%f, act=%f", 1000*math.Sqrt2, mag.Ip[0][0]))
+	assertFloat32Equals(t, 0, mag.Ip[0][4], "SobelMagnitude.center")
+}
+
+func TestPrewittMagnitudeOfVerticalEdge(t *testing.T) {
+	img := verticalEdgeImage(8, 6, 1000)
+	mag := img.PrewittMagnitude()
+	for i := 0; i < 3; i++ {
+		assertFloat32SliceEquals(t, []float32{0, 0, 0, 3000, 3000, 0, 0, 0}, mag.Ip[i][3*8:4*8], fmt.Sprintf("PrewittMagnitude[%d]", i))
+	}
+}
+
+func TestFocusPeakingHighlightsOnlySharpEdges(t *testing.T) {
+	// a sharp step on the left half, and a gentle ramp (one small step per column) on the right
+	img := grayImage(12, 1, 0, 0, 0, 40000, 40000, 40000, 40000, 41000, 42000, 43000, 44000, 45000)
+	res := img.FocusPeaking(20000, 65535, 0, 0)
+
+	for x := 0; x < img.Width; x++ {
+		sharp := x == 2 || x == 3
+		if sharp {
+			assertFloat32Equals(t, 65535, res.Ip[0][x], fmt.Sprintf("FocusPeaking[%d].red", x))
+			assertFloat32Equals(t, 0, res.Ip[1][x], fmt.Sprintf("FocusPeaking[%d].green", x))
+		} else {
+			for i := 0; i < 3; i++ {
+				assertFloat32Equals(t, img.Ip[i][x], res.Ip[i][x], fmt.Sprintf("FocusPeaking[%d][%d] should be unchanged", x, i))
+			}
+		}
+	}
+	assertFloat32Equals(t, 0, img.Ip[0][2], "FocusPeaking.original")
+}
+
+func TestEmbossOfFlatImageIsMidGray(t *testing.T) {
+	for _, direction := range []string{"NW", "NE", "SW", "SE"} {
+		img := solidImage(5, 4, 10000, 30000, 50000)
+		if err := img.Emboss(direction); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			for j, v := range img.Ip[i] {
+				assertFloat32Equals(t, MID_GRAY, v, fmt.Sprintf("Emboss[%s][%d][%d]", direction, i, j))
+			}
+		}
+	}
+}
+
+func TestEmbossLightsEdgesFacingTheLight(t *testing.T) {
+	// bright on the right: the edge faces west, so is lit from NW and shadowed from NE
+	lit, shadowed := verticalEdgeImage(8, 6, 1000), verticalEdgeImage(8, 6, 1000)
+	if err := lit.Emboss("NW"); err != nil {
+		t.Fatal(err)
+	}
+	if err := shadowed.Emboss("NE"); err != nil {
+		t.Fatal(err)
+	}
+	edge := 2*8 + 3
+	assert(t, lit.Ip[0][edge] > MID_GRAY, fmt.Sprintf("Emboss[NW]: edge should be lit, act=%f", lit.Ip[0][edge]))
+	assert(t, shadowed.Ip[0][edge] < MID_GRAY, fmt.Sprintf("Emboss[NE]: edge should be shadowed, act=%f", shadowed.Ip[0][edge]))
+}
+
+func TestEmbossRejectsUnknownDirectionWithoutModifying(t *testing.T) {
+	img := solidImage(2, 2, 100, 200, 300)
+	assert(t, img.Emboss("north") != nil, "Emboss should reject an unknown direction")
+	assertFloat32Equals(t, 100, img.Ip[0][0], "Emboss[rejected]")
+}
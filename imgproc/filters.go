@@ -1,6 +1,10 @@
 package imgproc
 
-import "math"
+import (
+	"fmt"
+	"math"
+	"strings"
+)
 
 // build up a NxN matrix, populated by zeros
 func emptyKernel(radius int) (area, diameter int, kernel []float32) {
@@ -63,15 +67,35 @@ func GaussianFilterKernel(radius int, variance float64) *ConvKernel {
 	}
 
 	// Normalize the kernel before returning
+	// (this cannot fail: the Gaussian weights are all positive, so can't sum to zero)
 	res := &ConvKernel{
 		Kernel: kernel,
 		Radius: radius,
 	}
-	res.Normalize()
+	if err := res.Normalize(); err != nil {
+		panic(err)
+	}
 	return res
 
 }
 
+// a 1D gaussian filter: 2*radius+1 weights of the (sampled) Gaussian function, normalized to sum to 1.
+// variance is the variance of the Gaussian (i.e. sigma squared).
+// The Gaussian is separable: the outer product of this with itself is GaussianFilterKernel(radius, variance),
+// so a 2D Gaussian blur can be applied as two 1D passes (see GaussianBlurSeparable).
+func Gaussian1DKernel(radius int, variance float64) []float32 {
+	weights := make([]float32, 2*radius+1)
+	sum := float32(0)
+	for x := -radius; x <= radius; x++ {
+		weights[x+radius] = float32(math.Exp(-0.5 * float64(x*x) / variance))
+		sum += weights[x+radius]
+	}
+	for i := range weights {
+		weights[i] /= sum
+	}
+	return weights
+}
+
 // laplacian operator: without diagonals
 // 0  1  0 
 // 1 -4  1 
@@ -98,3 +122,57 @@ func LaplaceSpherical() *ConvKernel {
 	n, o := float32(1), float32(-8) // neighbour, origin
 	return NewConvKernel3(n, n, n, n, o, n, n, n, n)
 }
+
+// sobel operator: horizontal gradient (responds to vertical edges)
+// -1  0  1
+// -2  0  2
+// -1  0  1
+func SobelX() *ConvKernel {
+	return NewConvKernel3(-1, 0, 1, -2, 0, 2, -1, 0, 1)
+}
+
+// sobel operator: vertical gradient (responds to horizontal edges)
+// -1 -2 -1
+// 0  0  0
+// 1  2  1
+func SobelY() *ConvKernel {
+	return NewConvKernel3(-1, -2, -1, 0, 0, 0, 1, 2, 1)
+}
+
+// prewitt operator: horizontal gradient (responds to vertical edges)
+// -1  0  1
+// -1  0  1
+// -1  0  1
+func PrewittX() *ConvKernel {
+	return NewConvKernel3(-1, 0, 1, -1, 0, 1, -1, 0, 1)
+}
+
+// prewitt operator: vertical gradient (responds to horizontal edges)
+// -1 -1 -1
+// 0  0  0
+// 1  1  1
+func PrewittY() *ConvKernel {
+	return NewConvKernel3(-1, -1, -1, 0, 0, 0, 1, 1, 1)
+}
+
+// emboss operators, by the direction the light appears to come from: e.g. for NW
+// -1 -1  0
+// -1  0  1
+// 0  1  1
+// The weights sum to zero, so flat regions give zero.
+var embossKernels = map[string][9]float32{
+	"NW": {-1, -1, 0, -1, 0, 1, 0, 1, 1},
+	"NE": {0, -1, -1, 1, 0, -1, 1, 1, 0},
+	"SW": {0, 1, 1, -1, 0, 1, -1, -1, 0},
+	"SE": {1, 1, 0, 1, 0, -1, 0, -1, -1},
+}
+
+// emboss operator, lit from the given direction: one of NW, NE, SW or SE (in either case).
+// Returns an error for any other direction.
+func EmbossKernel(direction string) (*ConvKernel, error) {
+	k, ok := embossKernels[strings.ToUpper(direction)]
+	if !ok {
+		return nil, fmt.Errorf("unknown emboss direction %q: expected NW, NE, SW or SE", direction)
+	}
+	return NewConvKernel3(k[0], k[1], k[2], k[3], k[4], k[5], k[6], k[7], k[8]), nil
+}
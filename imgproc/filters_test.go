@@ -5,13 +5,18 @@ package imgproc
 import (
 	"bytes"
 	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
 	"math"
+	"strconv"
 	"testing"
 )
 
 func assert(t *testing.T, cond bool, msg string) bool {
 	if !cond {
-		t.Errorf(msg)
+		t.Error(msg)
 	}
 	return cond
 }
@@ -70,7 +75,7 @@ func assertConvKernelEquals(t *testing.T, expKernel []float32, expRadius int, ac
 // helper
 func testEmptyKernelHasCorrectArea(t *testing.T, radius int) {
 
-	msg := "[radius=" + string(radius) + "]."
+	msg := "[radius=" + strconv.Itoa(radius) + "]."
 	area, diameter, kernel := emptyKernel(radius)
 
 	// diameter should be radius*2 + 1
@@ -156,6 +161,143 @@ func TestLaplaceSpherical(t *testing.T) {
 	assertConvKernelEquals(t, expKernel, expRadius, actKernel, "LaplaceSpherical")
 }
 
+func TestNormalizeScalesEntriesToSumToOne(t *testing.T) {
+	kernel := NewConvKernel3(1, 2, 1, 2, 4, 2, 1, 2, 1)
+	if err := kernel.Normalize(); err != nil {
+		t.Fatal(err)
+	}
+	assertConvKernelEquals(t, []float32{
+		0.0625, 0.125, 0.0625,
+		0.125, 0.25, 0.125,
+		0.0625, 0.125, 0.0625}, 1, kernel, "Normalize")
+}
+
+func TestNormalizeOfZeroSumKernelFails(t *testing.T) {
+	kernel := LaplaceSpherical()
+	err := kernel.Normalize()
+	assert(t, err != nil, "Normalize should fail for a kernel summing to zero")
+	assertConvKernelEquals(t, LaplaceSpherical().Kernel, 1, kernel, "Normalize[zero-sum]")
+}
+
+func TestNewConvKernelOf5x5(t *testing.T) {
+	expRadius := 2
+	expKernel := []float32{
+		1, 2, 3, 4, 5,
+		6, 7, 8, 9, 10,
+		11, 12, 13, 14, 15,
+		16, 17, 18, 19, 20,
+		21, 22, 23, 24, 25}
+	rows := make([][]float32, 5)
+	for y := range rows {
+		rows[y] = expKernel[y*5 : (y+1)*5]
+	}
+	actKernel, err := NewConvKernel(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertConvKernelEquals(t, expKernel, expRadius, actKernel, "NewConvKernel[5x5]")
+
+	// the entries are copied
+	rows[0][0] = 100
+	assertFloat32Equals(t, 1, actKernel.Kernel[0], "NewConvKernel.copied")
+}
+
+func TestNewConvKernelRejectsInvalidRows(t *testing.T) {
+	for title, rows := range map[string][][]float32{
+		"empty":  {},
+		"4x4":    {{1, 2, 3, 4}, {1, 2, 3, 4}, {1, 2, 3, 4}, {1, 2, 3, 4}},
+		"ragged": {{1, 2, 3}, {1, 2}, {1, 2, 3}},
+		"3x5":    {{1, 2, 3, 4, 5}, {1, 2, 3, 4, 5}, {1, 2, 3, 4, 5}},
+	} {
+		_, err := NewConvKernel(rows)
+		assert(t, err != nil, "NewConvKernel should reject "+title+" rows")
+	}
+}
+
+func TestTransposeOfSobelXIsSobelY(t *testing.T) {
+	assertConvKernelEquals(t, SobelY().Kernel, 1, SobelX().Transpose(), "SobelX.Transpose")
+	assertConvKernelEquals(t, PrewittX().Kernel, 1, PrewittY().Transpose(), "PrewittY.Transpose")
+}
+
+func TestTransposeSwapsAnchors(t *testing.T) {
+	kernel := GaussianFilterKernel(2, 1)
+	kernel.AnchorX, kernel.AnchorY = 1, -2
+	transposed := kernel.Transpose()
+	assertIntEquals(t, -2, transposed.AnchorX, "Transpose.AnchorX")
+	assertIntEquals(t, 1, transposed.AnchorY, "Transpose.AnchorY")
+}
+
+func TestRotate180OfSymmetricKernelIsUnchanged(t *testing.T) {
+	for title, kernel := range map[string]*ConvKernel{
+		"Gaussian":         GaussianFilterKernel(2, 1.5),
+		"LaplaceSpherical": LaplaceSpherical(),
+	} {
+		assertConvKernelEquals(t, kernel.Kernel, kernel.Radius, kernel.Rotate180(), title+".Rotate180")
+	}
+}
+
+func TestRotate180MatchesFlipped(t *testing.T) {
+	img := sequentialImage(5, 4)
+	kernel := SobelX()
+	rotated := img.ConvolveClamp(kernel.Rotate180())
+	kernel.Flipped = true
+	flipped := img.ConvolveClamp(kernel)
+	assertFloat32SliceEquals(t, flipped.Ip[0], rotated.Ip[0], "Rotate180 vs Flipped")
+}
+
+func TestPrewittX(t *testing.T) {
+	expRadius := 1
+	expKernel := []float32{
+		-1, 0, 1,
+		-1, 0, 1,
+		-1, 0, 1}
+	actKernel := PrewittX()
+	assertConvKernelEquals(t, expKernel, expRadius, actKernel, "PrewittX")
+}
+
+func TestPrewittY(t *testing.T) {
+	expRadius := 1
+	expKernel := []float32{
+		-1, -1, -1,
+		0, 0, 0,
+		1, 1, 1}
+	actKernel := PrewittY()
+	assertConvKernelEquals(t, expKernel, expRadius, actKernel, "PrewittY")
+}
+
+func TestEmbossKernels(t *testing.T) {
+	expRadius := 1
+	for direction, expKernel := range map[string][]float32{
+		"NW": {
+			-1, -1, 0,
+			-1, 0, 1,
+			0, 1, 1},
+		"NE": {
+			0, -1, -1,
+			1, 0, -1,
+			1, 1, 0},
+		"SW": {
+			0, 1, 1,
+			-1, 0, 1,
+			-1, -1, 0},
+		"se": {
+			1, 1, 0,
+			1, 0, -1,
+			0, -1, -1},
+	} {
+		actKernel, err := EmbossKernel(direction)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertConvKernelEquals(t, expKernel, expRadius, actKernel, "EmbossKernel["+direction+"]")
+	}
+}
+
+func TestEmbossKernelRejectsUnknownDirection(t *testing.T) {
+	_, err := EmbossKernel("up")
+	assert(t, err != nil, "EmbossKernel should reject an unknown direction")
+}
+
 // unit Gaussian filter kernel
 func TestGaussianFilterKernelOfRadiusZero(t *testing.T) {
 
@@ -183,3 +325,590 @@ func TestGaussianFilterKernelOfRadiusThree(t *testing.T) {
 	actKernel := GaussianFilterKernel(expRadius, variance)
 	assertConvKernelEquals(t, expKernel, expRadius, actKernel, "GaussianKernel[radius=3,sigma=0.84]")
 }
+
+func TestGaussian1DKernelIsNormalized(t *testing.T) {
+	weights := Gaussian1DKernel(4, 2.5)
+	assertIntEquals(t, 9, len(weights), "Gaussian1DKernel.length")
+	sum := float32(0)
+	for i, w := range weights {
+		sum += w
+		assertFloat32Equals(t, weights[len(weights)-1-i], w, "Gaussian1DKernel.symmetric")
+	}
+	assert(t, math.Abs(float64(sum-1)) < 1e-6, fmt.Sprintf("Gaussian1DKernel should sum to 1, act=%f", sum))
+}
+
+func TestGaussian1DKernelOuterProductMatches2DKernel(t *testing.T) {
+	for _, c := range []struct {
+		radius   int
+		variance float64
+	}{{0, 1}, {1, 1}, {3, 0.84089642 * 0.84089642}, {5, 4}} {
+		weights := Gaussian1DKernel(c.radius, c.variance)
+		dense := GaussianFilterKernel(c.radius, c.variance)
+		diameter := 2*c.radius + 1
+		for y := 0; y < diameter; y++ {
+			for x := 0; x < diameter; x++ {
+				exp, act := dense.Kernel[y*diameter+x], weights[y]*weights[x]
+				assert(t, math.Abs(float64(exp-act)) < 1e-6,
+					fmt.Sprintf("Gaussian1DKernel[radius=%d] outer product (%d,%d): exp=%f, act=%f", c.radius, x, y, exp, act))
+			}
+		}
+	}
+}
+
+// build a small image where each plane holds the values 0,1,2,... in row-major order
+func sequentialImage(width, height int) *FloatImage {
+	img := NewFloatImage(width, height)
+	for i := 0; i < 3; i++ {
+		for j := range img.Ip[i] {
+			img.Ip[i][j] = float32(j)
+		}
+	}
+	return img
+}
+
+func TestValidateAcceptsCorrectlySizedKernel(t *testing.T) {
+	kernel := &ConvKernel{Kernel: make([]float32, 25), Radius: 2, AnchorX: -2, AnchorY: 1}
+	err := kernel.Validate()
+	assert(t, err == nil, fmt.Sprintf("Validate should accept a 5x5 kernel of radius 2: %v", err))
+}
+
+func TestValidateRejectsMismatchedKernel(t *testing.T) {
+	kernel := &ConvKernel{Kernel: make([]float32, 9), Radius: 2}
+	err := kernel.Validate()
+	assert(t, err != nil, "Validate should reject a 3x3 kernel of radius 2")
+}
+
+func TestConvolveClampOfMismatchedKernelPanicsWithValidateError(t *testing.T) {
+	img := sequentialImage(3, 3)
+	badKernel := &ConvKernel{Kernel: []float32{1, 2, 3, 4}, Radius: 1}
+	defer func() {
+		err, ok := recover().(error)
+		assert(t, ok && err.Error() == badKernel.Validate().Error(),
+			fmt.Sprintf("ConvolveClamp should panic with the error from Validate, got: %v", err))
+	}()
+	img.ConvolveClamp(badKernel)
+}
+
+func TestConvolveClampERejectsMismatchedKernel(t *testing.T) {
+	img := sequentialImage(3, 3)
+	badKernel := &ConvKernel{Kernel: []float32{1, 2, 3, 4}, Radius: 1}
+
+	res, err := img.ConvolveClampE(badKernel)
+	assert(t, err != nil, "ConvolveClampE should reject a kernel with the wrong number of entries")
+	assert(t, res == nil, "ConvolveClampE should not return an image on error")
+
+	_, err = img.ConvolveWrapE(badKernel)
+	assert(t, err != nil, "ConvolveWrapE should reject a kernel with the wrong number of entries")
+}
+
+func TestConvolveClampERejectsNilAndNegativeKernels(t *testing.T) {
+	img := sequentialImage(3, 3)
+
+	_, err := img.ConvolveClampE(nil)
+	assert(t, err != nil, "ConvolveClampE should reject a nil kernel")
+
+	_, err = img.ConvolveClampE(&ConvKernel{Kernel: []float32{1}, Radius: -1})
+	assert(t, err != nil, "ConvolveClampE should reject a negative radius")
+}
+
+func TestConvolveClampWithERejectsDegenerateImage(t *testing.T) {
+	empty := NewFloatImage(0, 0)
+	err := empty.ConvolveClampWithE(MeanFilterKernel(1))
+	assert(t, err != nil, "ConvolveClampWithE should reject an empty image")
+
+	truncated := sequentialImage(3, 3)
+	truncated.Ip[1] = truncated.Ip[1][:4]
+	err = truncated.ConvolveWrapWithE(MeanFilterKernel(1))
+	assert(t, err != nil, "ConvolveWrapWithE should reject a plane of the wrong size")
+}
+
+func TestConvolveClampEAcceptsValidKernel(t *testing.T) {
+	img := sequentialImage(3, 3)
+	_, err := img.ConvolveClampE(MeanFilterKernel(1))
+	assert(t, err == nil, "ConvolveClampE should accept a well-formed kernel")
+}
+
+func TestConvolveClampWithIdentityKernelCopiesPlanes(t *testing.T) {
+	img := sequentialImage(4, 3)
+	identity := NewConvKernel3(0, 0, 0, 0, 1, 0, 0, 0, 0)
+	res := img.ConvolveClamp(identity)
+
+	assertIntEquals(t, img.Width, res.Width, "ConvolveClamp[identity].Width")
+	assertIntEquals(t, img.Height, res.Height, "ConvolveClamp[identity].Height")
+	for i := 0; i < 3; i++ {
+		assertFloat32SliceEquals(t, img.Ip[i], res.Ip[i], "ConvolveClamp[identity].Ip["+strconv.Itoa(i)+"]")
+	}
+}
+
+func TestAtRoundsToNearestLevel(t *testing.T) {
+	// (level x is 257x, as per color.RGBA: so 128*256-1 is just below 127.5, and 128*256 just above)
+	img := grayImage(8, 1, 0, 128, 129, 128*256-1, 128*256, 128*257, 254*257+129, INTENSITY_MAX)
+	exp := []uint8{0, 0, 1, 127, 128, 128, 255, 255}
+	for x, e := range exp {
+		act := img.At(x, 0).(color.RGBA)
+		assert(t, act == color.RGBA{e, e, e, RGBA_MAX_I}, fmt.Sprintf("At(%d,0) of %f: exp=%d, act=%v", x, img.Ip[0][x], e, act))
+	}
+}
+
+func TestAtRoundTripsDecodedLevels(t *testing.T) {
+	src := image.NewGray(image.Rect(0, 0, 256, 1))
+	for x := 0; x < 256; x++ {
+		src.Pix[x] = uint8(x)
+	}
+	img := ImageToFloatImage(src)
+	for x := 0; x < 256; x++ {
+		exp, act := src.At(x, 0).(color.Gray).Y, img.At(x, 0).(color.RGBA).R
+		assert(t, exp == act, fmt.Sprintf("At(%d,0): exp=%d, act=%d", x, exp, act))
+	}
+}
+
+func TestAtOutsideBoundsIsTransparent(t *testing.T) {
+	img := solidImage(3, 2, INTENSITY_MAX, INTENSITY_MAX, INTENSITY_MAX)
+	for _, p := range []image.Point{{-1, 0}, {3, 0}, {0, -1}, {0, 2}, {3, 2}} {
+		act := img.At(p.X, p.Y)
+		assert(t, act == color.RGBA{}, fmt.Sprintf("At%v: exp=transparent, act=%v", p, act))
+	}
+	assert(t, img.At(2, 1) == color.RGBA{255, 255, 255, 255}, "At should still read the last pixel")
+}
+
+func TestHashOfIdenticalImagesIsEqual(t *testing.T) {
+	img1, img2 := sequentialImage(5, 4), sequentialImage(5, 4)
+	assert(t, img1.Hash() == img2.Hash(), "identical images should have equal hashes")
+
+	// differences well below the quantization step don't change the hash
+	img2.Ip[2][7] += 0.01
+	assert(t, img1.Hash() == img2.Hash(), "sub-quantum differences should not change the hash")
+}
+
+func TestHashChangesWithOnePixel(t *testing.T) {
+	img1, img2 := sequentialImage(5, 4), sequentialImage(5, 4)
+	img2.Ip[1][12] += 1
+	assert(t, img1.Hash() != img2.Hash(), "changing one pixel should change the hash")
+}
+
+func TestHashOfHSVImageDependsOnSaturation(t *testing.T) {
+	img1, img2 := solidImage(2, 1, 40000, 20000, 20000), solidImage(2, 1, 40000, 16000, 16000)
+	img1.ToHSV()
+	img2.ToHSV()
+	assert(t, img1.Hash() != img2.Hash(), "HSV images of different saturations should have different hashes")
+}
+
+func TestHashDependsOnDimensions(t *testing.T) {
+	img1, img2 := NewFloatImage(4, 2), NewFloatImage(2, 4)
+	assert(t, img1.Hash() != img2.Hash(), "images of different shapes should have different hashes")
+}
+
+func TestConvolveWithClampAndWrapDifferAtEdges(t *testing.T) {
+	// asymmetric kernel: each pixel takes the value of its right-hand neighbour
+	rightNeighbour := NewConvKernel3(0, 0, 0, 0, 0, 1, 0, 0, 0)
+
+	clamped, wrapped := sequentialImage(4, 1), sequentialImage(4, 1)
+	clamped.ConvolveClampWith(rightNeighbour)
+	wrapped.ConvolveWrapWith(rightNeighbour)
+
+	// away from the edges, both agree
+	assertFloat32SliceEquals(t, []float32{1, 2, 3}, clamped.Ip[0][:3], "ConvolveClampWith.interior")
+	assertFloat32SliceEquals(t, []float32{1, 2, 3}, wrapped.Ip[0][:3], "ConvolveWrapWith.interior")
+
+	// at the right edge, clamping repeats the edge pixel but wrapping reads the left edge
+	assertFloat32Equals(t, 3, clamped.Ip[0][3], "ConvolveClampWith.edge")
+	assertFloat32Equals(t, 0, wrapped.Ip[0][3], "ConvolveWrapWith.edge")
+}
+
+func TestApplyAddsTwoImages(t *testing.T) {
+	img1, img2 := sequentialImage(3, 2), sequentialImage(3, 2)
+	img1.Apply(func(v ...float32) float32 { return v[0] + v[1] }, img2)
+
+	exp := []float32{0, 2, 4, 6, 8, 10}
+	for i := 0; i < 3; i++ {
+		assertFloat32SliceEquals(t, exp, img1.Ip[i], "Apply[sum].Ip["+strconv.Itoa(i)+"]")
+	}
+}
+
+func TestPackageApplyDoesNotModifyInputs(t *testing.T) {
+	img1, img2 := sequentialImage(3, 2), sequentialImage(3, 2)
+	res := Apply(func(v ...float32) float32 { return v[0] * v[1] }, img1, img2)
+
+	assertFloat32SliceEquals(t, []float32{0, 1, 4, 9, 16, 25}, res.Ip[0], "Apply[product]")
+	assertFloat32SliceEquals(t, []float32{0, 1, 2, 3, 4, 5}, img1.Ip[0], "Apply[product].original")
+}
+
+func TestWrapPlaneExtensionOfNegativeIndices(t *testing.T) {
+	assertIntEquals(t, 4, wrapPlaneExtension(-1, 5), "wrapPlaneExtension(-1,5)")
+	assertIntEquals(t, 3, wrapPlaneExtension(-2, 5), "wrapPlaneExtension(-2,5)")
+	assertIntEquals(t, 0, wrapPlaneExtension(5, 5), "wrapPlaneExtension(5,5)")
+	assertIntEquals(t, 2, wrapPlaneExtension(2, 5), "wrapPlaneExtension(2,5)")
+}
+
+func TestConvolveWrapCornerReadsOppositeEdges(t *testing.T) {
+	// 3x3 image with values:
+	// 0 1 2
+	// 3 4 5
+	// 6 7 8
+	img := sequentialImage(3, 3)
+
+	// kernels selecting a single neighbour of each pixel
+	upLeft := NewConvKernel3(1, 0, 0, 0, 0, 0, 0, 0, 0)
+	up := NewConvKernel3(0, 1, 0, 0, 0, 0, 0, 0, 0)
+	left := NewConvKernel3(0, 0, 0, 1, 0, 0, 0, 0, 0)
+
+	// for the top-left corner, these neighbours wrap to the bottom/right edges
+	assertFloat32Equals(t, 8, img.ConvolveWrap(upLeft).Ip[0][0], "ConvolveWrap[up-left].corner")
+	assertFloat32Equals(t, 6, img.ConvolveWrap(up).Ip[0][0], "ConvolveWrap[up].corner")
+	assertFloat32Equals(t, 2, img.ConvolveWrap(left).Ip[0][0], "ConvolveWrap[left].corner")
+}
+
+func TestReflectPlaneExtensionMirrorsAboutEdges(t *testing.T) {
+	assertIntEquals(t, 0, reflectPlaneExtension(-1, 5), "reflectPlaneExtension(-1,5)")
+	assertIntEquals(t, 1, reflectPlaneExtension(-2, 5), "reflectPlaneExtension(-2,5)")
+	assertIntEquals(t, 4, reflectPlaneExtension(5, 5), "reflectPlaneExtension(5,5)")
+	assertIntEquals(t, 3, reflectPlaneExtension(6, 5), "reflectPlaneExtension(6,5)")
+	assertIntEquals(t, 2, reflectPlaneExtension(2, 5), "reflectPlaneExtension(2,5)")
+	assertIntEquals(t, 0, reflectPlaneExtension(-3, 1), "reflectPlaneExtension(-3,1)")
+}
+
+// a kernel of the given radius, selecting the single neighbour at offset (dx,dy)
+func neighbourKernel(radius, dx, dy int) *ConvKernel {
+	diameter := 2*radius + 1
+	kernel := &ConvKernel{Kernel: make([]float32, diameter*diameter), Radius: radius}
+	kernel.Kernel[(dy+radius)*diameter+dx+radius] = 1
+	return kernel
+}
+
+func TestConvolveReflectCornerReadsMirroredNeighbours(t *testing.T) {
+	// 3x3 image with values:
+	// 0 1 2
+	// 3 4 5
+	// 6 7 8
+	img := sequentialImage(3, 3)
+
+	// for the top-left corner: (-1,-1) mirrors onto the corner itself, (-2,-2) onto (1,1), and (-2,0) onto (1,0)
+	assertFloat32Equals(t, 0, img.ConvolveReflect(neighbourKernel(1, -1, -1)).Ip[0][0], "ConvolveReflect[-1,-1].corner")
+	assertFloat32Equals(t, 4, img.ConvolveReflect(neighbourKernel(2, -2, -2)).Ip[0][0], "ConvolveReflect[-2,-2].corner")
+	assertFloat32Equals(t, 1, img.ConvolveReflect(neighbourKernel(2, -2, 0)).Ip[0][0], "ConvolveReflect[-2,0].corner")
+
+	// for the bottom-right corner: (2,2) mirrors onto (1,1), and (2,0) onto (1,2)
+	in := img.Clone()
+	in.ConvolveReflectWith(neighbourKernel(2, 2, 2))
+	assertFloat32Equals(t, 4, in.Ip[0][8], "ConvolveReflectWith[2,2].corner")
+	assertFloat32Equals(t, 7, img.ConvolveReflect(neighbourKernel(2, 2, 0)).Ip[0][8], "ConvolveReflect[2,0].corner")
+}
+
+func TestLaplacianNearBorderDiffersBetweenClampAndZero(t *testing.T) {
+	// a flat gray image: with clamping there are no edges at all,
+	// but with zero extension the border is an edge against the black outside.
+	img := solidImage(4, 4, 1000, 1000, 1000)
+	clamped := img.ConvolveClamp(LaplaceWithoutDiagonal())
+	zeroed := img.ConvolveZero(LaplaceWithoutDiagonal())
+
+	assertFloat32Equals(t, 0, clamped.Ip[0][0], "ConvolveClamp[laplace].corner")
+	assertFloat32Equals(t, 0, clamped.Ip[0][1], "ConvolveClamp[laplace].edge")
+	assertFloat32Equals(t, 0, zeroed.Ip[0][5], "ConvolveZero[laplace].interior")
+	assert(t, zeroed.Ip[0][0] != 0 && zeroed.Ip[0][1] != 0,
+		fmt.Sprintf("ConvolveZero[laplace] should respond at the border: corner=%f, edge=%f", zeroed.Ip[0][0], zeroed.Ip[0][1]))
+	assert(t, math.Abs(float64(zeroed.Ip[0][0])) > math.Abs(float64(zeroed.Ip[0][1])),
+		"ConvolveZero[laplace]: the corner has more missing neighbours than the edge")
+}
+
+// FloatImage can be used as the destination of the standard image/draw functions.
+var _ draw.Image = (*FloatImage)(nil)
+
+func TestDrawOntoFloatImage(t *testing.T) {
+	img := NewFloatImage(4, 3)
+	red := &image.Uniform{color.RGBA{255, 0, 0, 255}}
+	draw.Draw(img, image.Rect(1, 1, 3, 3), red, image.Point{}, draw.Src)
+
+	for y := 0; y < img.Height; y++ {
+		for x := 0; x < img.Width; x++ {
+			i := y*img.Width + x
+			expRed := float32(0)
+			if x >= 1 && x < 3 && y >= 1 {
+				expRed = 65535
+			}
+			title := fmt.Sprintf("draw.Draw(%d,%d)", x, y)
+			assertFloat32Equals(t, expRed, img.Ip[0][i], title+".red")
+			assertFloat32Equals(t, 0, img.Ip[1][i], title+".green")
+			assertFloat32Equals(t, 0, img.Ip[2][i], title+".blue")
+		}
+	}
+
+	// and the color reads back through At
+	assert(t, img.At(1, 1) == color.RGBA{255, 0, 0, 255}, fmt.Sprintf("At(1,1) after Set: %v", img.At(1, 1)))
+}
+
+func TestSetThenAtRoundTripsInEachColorSpace(t *testing.T) {
+	colors := []color.RGBA{{0, 0, 0, 255}, {255, 255, 255, 255}, {200, 30, 90, 255}, {12, 160, 100, 255}}
+	for space, convert := range []func(*FloatImage){RGB: (*FloatImage).ToRGB, YCrCb: (*FloatImage).ToYCrCb, HSV: (*FloatImage).ToHSV} {
+		img := testColorsImage()
+		convert(img)
+		for x := range testColors {
+			orig := img.At(x, 0)
+			img.Set(x, 0, orig)
+			assert(t, img.At(x, 0) == orig, fmt.Sprintf("Set(At)[space=%d](%d,0): exp=%v, act=%v", space, x, orig, img.At(x, 0)))
+		}
+		for x, c := range colors {
+			img.Set(x, 0, c)
+			assert(t, img.At(x, 0) == c, fmt.Sprintf("Set[space=%d](%d,0): exp=%v, act=%v", space, x, c, img.At(x, 0)))
+		}
+		assert(t, img.Space == ColorSpace(space), "Set should not change the color space")
+	}
+}
+
+func TestDrawingOntoYCrCbImageDrawsRGB(t *testing.T) {
+	img := NewFloatImage(3, 1)
+	img.ToYCrCb()
+	img.DrawLine(0, 0, 2, 0, 65535, 0, 0)
+	for x := 0; x < 3; x++ {
+		assert(t, img.At(x, 0) == color.RGBA{255, 0, 0, 255}, fmt.Sprintf("DrawLine[YCrCb](%d,0): %v", x, img.At(x, 0)))
+	}
+}
+
+func TestSetOutsideBoundsIsIgnored(t *testing.T) {
+	img := NewFloatImage(2, 2)
+	img.Set(2, 0, color.White)
+	img.Set(-1, 1, color.White)
+	assertFloat32SliceEquals(t, []float32{0, 0, 0, 0}, img.Ip[0], "Set[out-of-bounds]")
+}
+
+func TestImageToFloatImageOfPalettedPng(t *testing.T) {
+	palette := color.Palette{
+		color.RGBA{255, 0, 0, 255},  // opaque red
+		color.NRGBA{0, 255, 0, 0},   // fully transparent green
+		color.NRGBA{0, 0, 255, 128}, // half transparent blue
+	}
+	src := image.NewPaletted(image.Rect(0, 0, 3, 1), palette)
+	src.Pix = []uint8{0, 1, 2}
+
+	// round trip through a png, which stores the transparency in a tRNS chunk
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := decoded.(*image.Paletted); !ok {
+		t.Fatalf("expected a paletted image, decoded a %T", decoded)
+	}
+
+	// colors are premultiplied by alpha: transparent entries flatten towards black
+	img := ImageToFloatImage(decoded)
+	assertFloat32SliceEquals(t, []float32{65535, 0, 0}, img.Ip[0], "ImageToFloatImage[paletted].red")
+	assertFloat32SliceEquals(t, []float32{0, 0, 0}, img.Ip[1], "ImageToFloatImage[paletted].green")
+	assertFloat32SliceEquals(t, []float32{0, 0, 0x8080}, img.Ip[2], "ImageToFloatImage[paletted].blue")
+}
+
+func TestApplyBroadcastSubtractsConstantImage(t *testing.T) {
+	img := sequentialImage(3, 2)
+	offset := NewFloatImage(1, 1)
+	offset.Ip[0][0], offset.Ip[1][0], offset.Ip[2][0] = 1, 2, 3
+
+	err := img.ApplyBroadcast(func(v ...float32) float32 { return v[0] - v[1] }, offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertFloat32SliceEquals(t, []float32{-1, 0, 1, 2, 3, 4}, img.Ip[0], "ApplyBroadcast[-1]")
+	assertFloat32SliceEquals(t, []float32{-2, -1, 0, 1, 2, 3}, img.Ip[1], "ApplyBroadcast[-2]")
+	assertFloat32SliceEquals(t, []float32{-3, -2, -1, 0, 1, 2}, img.Ip[2], "ApplyBroadcast[-3]")
+}
+
+func TestApplyBroadcastMixesFullSizeAndScalarImages(t *testing.T) {
+	img, other := sequentialImage(2, 2), sequentialImage(2, 2)
+	scale := NewFloatImage(1, 1)
+	scale.Ip[0][0] = 10
+
+	err := img.ApplyBroadcast(func(v ...float32) float32 { return v[0] + v[1]*v[2] }, other, scale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertFloat32SliceEquals(t, []float32{0, 11, 22, 33}, img.Ip[0], "ApplyBroadcast[mixed]")
+}
+
+func TestApplyWhereBrightensOnlyShadows(t *testing.T) {
+	img := grayImage(4, 1, 1000, 20000, 40000, 60000)
+	inShadow := func(vals ...float32) bool { return luminance(vals[0], vals[1], vals[2]) < 30000 }
+	img.ApplyWhere(inShadow, func(vals ...float32) float32 { return vals[0] + 5000 })
+
+	for i := 0; i < 3; i++ {
+		assertFloat32SliceEquals(t, []float32{6000, 25000, 40000, 60000}, img.Ip[i], "ApplyWhere["+strconv.Itoa(i)+"]")
+	}
+}
+
+func TestApplyWhereTestsThePixelBeforeModifyingIt(t *testing.T) {
+	// the map would take the pixel out of the selection after the first plane:
+	// the remaining planes must still be mapped.
+	img := solidImage(1, 1, 100, 100, 100)
+	offset := solidImage(1, 1, 1000, 2000, 3000)
+	img.ApplyWhere(func(vals ...float32) bool { return vals[0] < 500 },
+		func(vals ...float32) float32 { return vals[0] + vals[1] }, offset)
+	assertFloat32Equals(t, 1100, img.Ip[0][0], "ApplyWhere[0]")
+	assertFloat32Equals(t, 2100, img.Ip[1][0], "ApplyWhere[1]")
+	assertFloat32Equals(t, 3100, img.Ip[2][0], "ApplyWhere[2]")
+}
+
+func TestApplyBroadcastRejectsMismatchedImage(t *testing.T) {
+	img := sequentialImage(3, 2)
+	err := img.ApplyBroadcast(func(v ...float32) float32 { return v[0] + v[1] }, sequentialImage(2, 2))
+	assert(t, err != nil, "ApplyBroadcast should reject a 2x2 image against a 3x2 image")
+	assertFloat32SliceEquals(t, []float32{0, 1, 2, 3, 4, 5}, img.Ip[0], "ApplyBroadcast[rejected]")
+}
+
+func TestShiftedAnchorTranslatesImage(t *testing.T) {
+	img := sequentialImage(4, 3)
+	identity := NewConvKernel3(0, 0, 0, 0, 1, 0, 0, 0, 0)
+
+	// anchored one cell right of center: each output pixel reads the source pixel to its left
+	identity.AnchorX = 1
+	exp := []float32{
+		0, 0, 1, 2,
+		4, 4, 5, 6,
+		8, 8, 9, 10,
+	}
+	assertFloat32SliceEquals(t, exp, img.ConvolveClamp(identity).Ip[0], "ConvolveClamp[anchor=(1,0)]")
+
+	// anchored one cell above center: each output pixel reads the source pixel below it
+	identity.AnchorX, identity.AnchorY = 0, -1
+	exp = []float32{
+		4, 5, 6, 7,
+		8, 9, 10, 11,
+		8, 9, 10, 11,
+	}
+	assertFloat32SliceEquals(t, exp, img.ConvolveClamp(identity).Ip[0], "ConvolveClamp[anchor=(0,-1)]")
+}
+
+func TestConvolveClampERejectsAnchorOutsideKernel(t *testing.T) {
+	img := sequentialImage(3, 3)
+	kernel := NewConvKernel3(0, 0, 0, 0, 1, 0, 0, 0, 0)
+	kernel.AnchorY = 2
+	_, err := img.ConvolveClampE(kernel)
+	assert(t, err != nil, "ConvolveClampE should reject an anchor outside the kernel")
+}
+
+func TestCorrelationAndConvolutionAreMirrored(t *testing.T) {
+	// a single bright pixel: its response draws the kernel as applied
+	img := NewFloatImage(3, 3)
+	img.Ip[0][4] = 1
+
+	correlate := NewConvKernel3(1, 2, 3, 4, 5, 6, 7, 8, 9)
+	convolve := NewConvKernel3(1, 2, 3, 4, 5, 6, 7, 8, 9)
+	convolve.Flipped = true
+
+	// convolution reproduces the kernel; correlation reproduces it rotated by 180 degrees
+	assertFloat32SliceEquals(t, []float32{1, 2, 3, 4, 5, 6, 7, 8, 9}, img.ConvolveClamp(convolve).Ip[0], "ConvolveClamp[flipped]")
+	assertFloat32SliceEquals(t, []float32{9, 8, 7, 6, 5, 4, 3, 2, 1}, img.ConvolveClamp(correlate).Ip[0], "ConvolveClamp[correlation]")
+}
+
+func TestFlippedSymmetricKernelIsUnchanged(t *testing.T) {
+	img := sequentialImage(4, 4)
+	kernel := GaussianFilterKernel(1, 1)
+	plain := img.ConvolveClamp(kernel)
+	kernel.Flipped = true
+	assertFloat32SliceEquals(t, plain.Ip[0], img.ConvolveClamp(kernel).Ip[0], "ConvolveClamp[symmetric,flipped]")
+}
+
+func TestDecodeFloatImageRegionMatchesCrop(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 6, 5))
+	for y := 0; y < 5; y++ {
+		for x := 0; x < 6; x++ {
+			src.Set(x, y, color.RGBA{uint8(40 * x), uint8(50 * y), uint8(x + y), 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buf.Bytes()
+
+	region, err := DecodeFloatImageRegion(bytes.NewReader(encoded), image.Rect(2, 1, 5, 4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	full, err := png.Decode(bytes.NewReader(encoded))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp, err := ImageToFloatImage(full).Crop(2, 1, 5, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertIntEquals(t, 3, region.Width, "DecodeFloatImageRegion.Width")
+	assertIntEquals(t, 3, region.Height, "DecodeFloatImageRegion.Height")
+	for i := 0; i < 3; i++ {
+		assertFloat32SliceEquals(t, exp.Ip[i], region.Ip[i], "DecodeFloatImageRegion["+strconv.Itoa(i)+"]")
+	}
+}
+
+func TestDecodeFloatImageRegionRejectsRegionOutsideImage(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buf.Bytes()
+	for _, rect := range []image.Rectangle{image.Rect(2, 2, 5, 3), image.Rect(1, 1, 1, 3)} {
+		_, err := DecodeFloatImageRegion(bytes.NewReader(encoded), rect)
+		assert(t, err != nil, fmt.Sprintf("DecodeFloatImageRegion should reject %v", rect))
+	}
+}
+
+// record each fraction reported to a Progress callback
+func recordProgress(fractions *[]float64) Progress {
+	return func(fraction float64) {
+		*fractions = append(*fractions, fraction)
+	}
+}
+
+// check that the reported fractions rise steadily to 1
+func assertProgressCompletes(t *testing.T, fractions []float64, title string) {
+	assert(t, len(fractions) > 1, title+": progress should be reported more than once")
+	for i := 1; i < len(fractions); i++ {
+		assert(t, fractions[i] > fractions[i-1], fmt.Sprintf("%s: progress should increase: %v", title, fractions))
+	}
+	assert(t, math.Abs(fractions[len(fractions)-1]-1) < 1e-9, fmt.Sprintf("%s: progress should reach 1: %v", title, fractions))
+}
+
+func TestConvolveClampProgressReachesCompletion(t *testing.T) {
+	img := sequentialImage(6, 5)
+	kernel := GaussianFilterKernel(2, 1)
+	var fractions []float64
+	res := img.ConvolveClampProgress(kernel, recordProgress(&fractions))
+
+	assertProgressCompletes(t, fractions, "ConvolveClampProgress")
+	exp := img.ConvolveClamp(kernel)
+	for i := 0; i < 3; i++ {
+		assertFloat32SliceEquals(t, exp.Ip[i], res.Ip[i], "ConvolveClampProgress["+strconv.Itoa(i)+"]")
+	}
+}
+
+func TestResizeCubicProgressReachesCompletion(t *testing.T) {
+	var fractions []float64
+	sequentialImage(4, 4).ResizeCubicProgress(7, 9, CatmullRom, false, CenterAligned, recordProgress(&fractions))
+	assertProgressCompletes(t, fractions, "ResizeCubicProgress")
+}
+
+func TestResizeAndBlurProgressReachesCompletion(t *testing.T) {
+	img := sequentialImage(12, 8)
+	for title, run := range map[string]func(Progress) *FloatImage{
+		"ResizeBilinearProgress":        func(p Progress) *FloatImage { return img.ResizeBilinearProgress(7, 9, CenterAligned, p) },
+		"ResizeWithPreblurProgress":     func(p Progress) *FloatImage { return img.ResizeWithPreblurProgress(4, 3, CenterAligned, p) },
+		"ResizeWithPreblurProgress[up]": func(p Progress) *FloatImage { return img.ResizeWithPreblurProgress(24, 16, CenterAligned, p) },
+		"GaussianBlurSeparableProgress": func(p Progress) *FloatImage { return img.GaussianBlurSeparableProgress(2, 1, p) },
+	} {
+		var fractions []float64
+		res := run(recordProgress(&fractions))
+		assertProgressCompletes(t, fractions, title)
+
+		// the progress doesn't change the result
+		exp := run(nil)
+		for i := 0; i < 3; i++ {
+			assertFloat32SliceEquals(t, exp.Ip[i], res.Ip[i], title+"["+strconv.Itoa(i)+"]")
+		}
+	}
+}
+
+func TestNilProgressIsIgnored(t *testing.T) {
+	img := sequentialImage(4, 4)
+	img.ConvolveWrapProgress(GaussianFilterKernel(1, 1), nil)
+	img.ResizeCubicProgress(2, 2, Mitchell, true, CornerAligned, nil)
+}
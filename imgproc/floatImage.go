@@ -7,22 +7,30 @@
 package imgproc
 
 import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
 	"image"
 	"image/color"
+	"io"
 	"math"
 )
 
 const TOLERANCE = float64(0.0000001) // for comparing floating point numbers
 
 // FloatImage represents an image consisting 3 independent intensity planes
-// (either RGB or YCrCb based on the original colorModel).
+// (RGB, YCrCb or HSV, as given by Space).
 // Each intensity plane consists of an array of intensities, 
-// each represented as a float32, a number in the range [0,65536).
+// each represented as a float32: for RGB and YCrCb, a number in the range [0,65536).
+// (HSV is the exception: its hue plane is in degrees, in [0,360), and its saturation and
+// value planes are in [0,1]).
 // Each intensity plane is stored independently (rather than interleaving)
 // which is useful for (the cache locality of) operations which operate on one plane at a time.
 type FloatImage struct {
 	Ip            [3][]float32 // intensity planes
 	Width, Height int          // dimensions
+	Space         ColorSpace   // how the intensity planes are interpreted
 }
 
 // Construct a new FloatImage of the specified dimensions, with all pixels zero'd.
@@ -35,9 +43,17 @@ func NewFloatImage(width, height int) *FloatImage {
 	}
 }
 
-// convert an image (read by Decode) into a floatImage
+// convert an image (read by Decode) into a floatImage.
+// Any image type is accepted, including paletted (indexed) images, whose colors are
+// looked up in the palette. FloatImage has no alpha channel, so colors are read
+// premultiplied by alpha: (partially) transparent pixels, e.g. from a palette's
+// transparency (tRNS) entries, are flattened onto black.
 func ImageToFloatImage(img image.Image) *FloatImage {
-	b := img.Bounds()
+	return regionToFloatImage(img, img.Bounds())
+}
+
+// convert the region b (which must lie within the bounds) of an image into a floatImage
+func regionToFloatImage(img image.Image, b image.Rectangle) *FloatImage {
 	width := b.Max.X - b.Min.X
 	height := b.Max.Y - b.Min.Y
 
@@ -48,7 +64,7 @@ func ImageToFloatImage(img image.Image) *FloatImage {
 		for xi := 0; xi < width; xi++ {
 			i := yi*width + xi
 			// r,g,b are alpha-pre-multiplied, so alpha can be ignored.
-			// TODO use YCrCb instead?
+			// (use ToYCrCb to convert the result to YCrCb).
 			r, g, b, _ := img.At(xi+b.Min.X, yi+b.Min.Y).RGBA()
 			res.Ip[0][i], res.Ip[1][i], res.Ip[2][i] = float32(r), float32(g), float32(b)
 		}
@@ -57,22 +73,67 @@ func ImageToFloatImage(img image.Image) *FloatImage {
 	return res
 }
 
+// DecodeFloatImageRegion decodes an image, returning only the region rect as a FloatImage
+// (i.e. as per ImageToFloatImage followed by Crop, with rect in the image's own coordinates).
+// Only the region is converted, but the whole image is still decoded: true partial decoding
+// is format-dependent (e.g. tiled formats), and not supported by the standard decoders.
+// As with image.Decode, the decoders for the expected formats must be registered (imported) by the caller.
+// Returns an error if the image cannot be decoded, or rect is empty or extends outside the image.
+func DecodeFloatImageRegion(r io.ReadSeeker, rect image.Rectangle) (*FloatImage, error) {
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	if rect.Empty() {
+		return nil, fmt.Errorf("region %v is empty", rect)
+	}
+	if !rect.In(img.Bounds()) {
+		return nil, fmt.Errorf("region %v extends outside the image bounds %v", rect, img.Bounds())
+	}
+	return regionToFloatImage(img, rect), nil
+}
+
 const RGBA_MAX_I = uint8(255)
 const RGBA_MAX_F = float64(255)
-const SCALE_CONST = float64(256) // converting from [0,65536) to [0,256)
+const SCALE_CONST = float64(256)     // converting from [0,65536) to [0,256)
+const INTENSITY_MAX = float32(65535) // largest intensity in the [0,65536) range
 
 func (img *FloatImage) Bounds() image.Rectangle { return image.Rect(0, 0, img.Width, img.Height) }
 
 func (img *FloatImage) ColorModel() color.Model { return color.RGBAModel }
 
+// convert an intensity in the [0,65536) range into an 8-bit value, as output by At.
+// Rounds to the nearest value (truncating would bias every output down by half a level),
+// scaling 65535 to 255: so the 8-bit values of a decoded image (stored as 257x, as per color.RGBA)
+// round-trip exactly.
+func toUint8(v float32) uint8 {
+	return uint8(math.Max(math.Min(RGBA_MAX_F, math.Floor(float64(v)*RGBA_MAX_F/float64(INTENSITY_MAX)+0.5)), 0))
+}
+
+// Return the color of the pixel at (x,y), as an opaque 8-bit RGBA color.
+// As with the standard image types, coordinates outside the image give a transparent zero color
+// (rather than reading a pixel from a neighbouring row).
 func (img *FloatImage) At(x, y int) color.Color {
-	// a fn for converting from float64 to int
-	fti := func(v float32) uint8 {
-		return uint8(math.Max(math.Min(RGBA_MAX_F, float64(v)/SCALE_CONST), 0))
+	if !(image.Point{x, y}.In(img.Bounds())) {
+		return color.RGBA{}
 	}
 
 	i := x + y*img.Width
-	return color.RGBA{fti(img.Ip[0][i]), fti(img.Ip[1][i]), fti(img.Ip[2][i]), RGBA_MAX_I}
+	r, g, b := img.rgbAt(i)
+	return color.RGBA{toUint8(r), toUint8(g), toUint8(b), RGBA_MAX_I}
+}
+
+// Set the pixel at (x,y) to the color c, so that FloatImage implements draw.Image.
+// The color's (alpha-premultiplied) 16-bit RGBA components are already in the [0,65536) range,
+// so they are stored directly (after converting into the image's color space, as the inverse of At).
+// Coordinates outside the image are ignored.
+func (img *FloatImage) Set(x, y int, c color.Color) {
+	if !(image.Point{x, y}.In(img.Bounds())) {
+		return
+	}
+
+	r, g, b, _ := c.RGBA()
+	img.setRGBAt(x+y*img.Width, float32(r), float32(g), float32(b))
 }
 
 func (img *FloatImage) Clone() *FloatImage {
@@ -80,9 +141,47 @@ func (img *FloatImage) Clone() *FloatImage {
 	for i := 0; i < 3; i++ {
 		copy(res.Ip[i], img.Ip[i]) // NOTE: copy args are (dst, src)
 	}
+	res.Space = img.Space
 	return res
 }
 
+// Compute a fingerprint of the image, e.g. for checking that output is reproducible.
+// Intensities are quantized (rounded to the nearest integer, and clamped into [0,65535])
+// before hashing, so the hash is stable across platforms and small floating point differences.
+// (The planes of an HSV image are first scaled up to the [0,65535] range, so that e.g. saturations
+// in [0,1] aren't all quantized to 0 or 1).
+// Two images with the same dimensions, color space and quantized intensities have the same hash.
+func (img *FloatImage) Hash() uint64 {
+	h := fnv.New64a()
+	buf := make([]byte, 8)
+
+	binary.BigEndian.PutUint32(buf[0:4], uint32(img.Width))
+	binary.BigEndian.PutUint32(buf[4:8], uint32(img.Height))
+	h.Write(buf)
+	h.Write([]byte{byte(img.Space)})
+
+	scale := [3]float32{1, 1, 1}
+	if img.Space == HSV {
+		scale = [3]float32{INTENSITY_MAX / 360, INTENSITY_MAX, INTENSITY_MAX}
+	}
+
+	for i := 0; i < 3; i++ {
+		for _, v := range img.Ip[i] {
+			binary.BigEndian.PutUint16(buf[0:2], quantize(v*scale[i]))
+			h.Write(buf[0:2])
+		}
+	}
+	return h.Sum64()
+}
+
+// round an intensity to the nearest integer in [0,65535]. NaN is treated as zero.
+func quantize(v float32) uint16 {
+	if v != v {
+		return 0
+	}
+	return uint16(math.Max(math.Min(float64(INTENSITY_MAX), math.Floor(float64(v)+0.5)), 0))
+}
+
 // A ConvKernel is a kernel (a NxN matrix) for a Convolution operation.
 // The NxN matrix is stored as a 1D array in row-major order.
 // (I.e. index-of(x,y) is (y*WIDTH + x))
@@ -90,9 +189,20 @@ func (img *FloatImage) Clone() *FloatImage {
 // the size of the matrix must be odd (i.e. N = 2R + 1, for some Radius R)
 // Thus, the matrix has (2*Radius + 1)^2 elements.
 // For example, a 3x3 matrix has radius of 1 and has 9 elements.
+// By default, the center of the matrix is aligned with each output pixel;
+// AnchorX and AnchorY shift the aligned cell away from the center (each in [-Radius, Radius]),
+// e.g. for directional filters. A positive AnchorX aligns a cell to the right of center,
+// which translates the result right by AnchorX pixels.
+// By default, the kernel is applied as a correlation: each cell weighs the pixel at the
+// same offset (e.g. the top-left cell weighs the pixel above-left of the output pixel).
+// If Flipped is set, the kernel is rotated 180 degrees first, giving a true convolution.
+// The two are equivalent for symmetric kernels (e.g. Gaussian, Laplacian),
+// but mirror each other for asymmetric ones. (The anchor refers to the kernel as applied).
 type ConvKernel struct {
-	Kernel []float32
-	Radius int
+	Kernel           []float32
+	Radius           int
+	AnchorX, AnchorY int  // offset of the anchor cell from the center
+	Flipped          bool // true convolution (rather than correlation)
 }
 
 // a convenience function for creating 3x3 convolution kernels.
@@ -103,10 +213,58 @@ func NewConvKernel3(m11, m12, m13, m21, m22, m23, m31, m32, m33 float32) *ConvKe
 	}
 }
 
+// create a convolution kernel of any (odd) size from its rows, e.g. a 5x5 kernel from 5 rows of 5 entries.
+// The entries are copied, in row-major order.
+// Returns an error if the rows are empty, ragged, not square, or of an even size (which has no center).
+func NewConvKernel(rows [][]float32) (*ConvKernel, error) {
+	diameter := len(rows)
+	if diameter%2 == 0 {
+		return nil, fmt.Errorf("convolution kernel must have an odd number of rows, but has %d", diameter)
+	}
+
+	kernel := make([]float32, 0, diameter*diameter)
+	for i, row := range rows {
+		if len(row) != diameter {
+			return nil, fmt.Errorf("convolution kernel must be square (%dx%d), but row %d has %d entries",
+				diameter, diameter, i, len(row))
+		}
+		kernel = append(kernel, row...)
+	}
+	return &ConvKernel{Kernel: kernel, Radius: diameter / 2}, nil
+}
+
+// Transpose the kernel matrix, i.e. swap its rows and columns (and its AnchorX and AnchorY),
+// e.g. turning a horizontal gradient kernel into the matching vertical one.
+// Returns a new kernel (the original is not modified).
+func (k *ConvKernel) Transpose() *ConvKernel {
+	diameter := k.Radius*2 + 1
+	kernel := make([]float32, len(k.Kernel))
+	for y := 0; y < diameter; y++ {
+		for x := 0; x < diameter; x++ {
+			kernel[x*diameter+y] = k.Kernel[y*diameter+x]
+		}
+	}
+	return &ConvKernel{Kernel: kernel, Radius: k.Radius, AnchorX: k.AnchorY, AnchorY: k.AnchorX, Flipped: k.Flipped}
+}
+
+// Rotate the kernel matrix by 180 degrees (i.e. reverse its entries).
+// As the library applies kernels as correlations (unless Flipped is set), applying the rotated kernel
+// gives the true convolution with the original kernel: the same result as setting Flipped.
+// The anchor and Flipped are kept, as they refer to the kernel as applied.
+// Returns a new kernel (the original is not modified).
+func (k *ConvKernel) Rotate180() *ConvKernel {
+	kernel := make([]float32, len(k.Kernel))
+	for i, v := range k.Kernel {
+		kernel[len(kernel)-1-i] = v
+	}
+	return &ConvKernel{Kernel: kernel, Radius: k.Radius, AnchorX: k.AnchorX, AnchorY: k.AnchorY, Flipped: k.Flipped}
+}
+
 // Normalize the ConvKernel such that sum of all entries in the kernel matrix is 1. 
-// If the current kernel entries sum to zero, no change is made.
+// Returns an error (leaving the kernel unchanged) if the entries sum to zero (within TOLERANCE),
+// as such a kernel (e.g. a Laplacian, or an edge detector) cannot be normalized.
 // Modifies the current kernel.
-func (k *ConvKernel) Normalize() {
+func (k *ConvKernel) Normalize() error {
 	diameter := k.Radius*2 + 1
 	area := diameter * diameter
 	sum := float32(0)
@@ -114,14 +272,18 @@ func (k *ConvKernel) Normalize() {
 		sum += k.Kernel[i]
 	}
 
-	// only attempt to normalize if the sum is significantly
-	// different from both zero and one.
 	fSum := float64(sum)
-	if (math.Abs(fSum) >= TOLERANCE) && (math.Abs(fSum-1.0) >= TOLERANCE) {
+	if math.Abs(fSum) < TOLERANCE {
+		return fmt.Errorf("cannot normalize a convolution kernel whose entries sum to zero (sum=%g)", fSum)
+	}
+
+	// only normalize if the sum is significantly different from one.
+	if math.Abs(fSum-1.0) >= TOLERANCE {
 		for i := 0; i < area; i++ {
 			k.Kernel[i] /= sum // normalize by dividing each entry
 		}
 	}
+	return nil
 }
 
 // a function for making sure a coord lies within plane bounds
@@ -140,14 +302,81 @@ func clampPlaneExtension(index, limit int) int {
 }
 
 // edge wrapping: wrap out-of-bounds pixels around the image.
-func wrapPlaneExtension(index, limit int) int { return index % limit }
+// (Go's % keeps the sign of the index, so negative indices need shifting back into range).
+func wrapPlaneExtension(index, limit int) int { return ((index % limit) + limit) % limit }
+
+// edge reflection: mirror out-of-bounds pixels about the edges, repeating the edge pixel
+// (e.g. -1 -> 0, -2 -> 1, limit -> limit-1). The mirrored plane repeats every 2*limit pixels.
+func reflectPlaneExtension(index, limit int) int {
+	index = wrapPlaneExtension(index, 2*limit)
+	if index >= limit {
+		index = 2*limit - 1 - index
+	}
+	return index
+}
+
+// sentinel index for an out-of-bounds pixel which contributes nothing (i.e. is treated as zero).
+const OUTSIDE_PLANE = -1
+
+// zero extension: out-of-bounds pixels are treated as zero, by mapping them onto OUTSIDE_PLANE.
+// (Only convolvePlane understands the sentinel: other users of a planeExtension must not be given this one).
+func zeroPlaneExtension(index, limit int) int {
+	if index < 0 || index >= limit {
+		return OUTSIDE_PLANE
+	}
+	return index
+}
+
+// EdgeMode selects how a convolution treats the pixels beyond the edges of the image.
+type EdgeMode int
+
+const (
+	// EdgeClamp repeats the edge pixels (the default, as per ConvolveClamp).
+	EdgeClamp EdgeMode = iota
+
+	// EdgeWrap wraps around to the opposite edge (as per ConvolveWrap).
+	EdgeWrap
+
+	// EdgeReflect mirrors the image about its edges (as per ConvolveReflect).
+	EdgeReflect
+
+	// EdgeZero treats the pixels beyond the edges as zero (as per ConvolveZero).
+	EdgeZero
+)
+
+// the plane extension implementing the edge mode
+func (mode EdgeMode) planeExtension() planeExtension {
+	switch mode {
+	case EdgeWrap:
+		return wrapPlaneExtension
+	case EdgeReflect:
+		return reflectPlaneExtension
+	case EdgeZero:
+		return zeroPlaneExtension
+	}
+	return clampPlaneExtension
+}
+
+// Progress is called periodically by long-running operations, with the fraction
+// of the work completed so far (rising to 1 when the operation completes).
+// Wherever a Progress is accepted, nil may be passed for no progress reports.
+type Progress func(fraction float64)
+
+// report that done out of total units of work are complete (if there is a callback).
+func (progress Progress) report(done, total int) {
+	if progress != nil {
+		progress(float64(done) / float64(total))
+	}
+}
 
 // helper function for convolving a single intensity plane.
-func convolvePlane(planePtr *[]float32, kernel *ConvKernel, width, height int, toPlaneCoords planeExtension) *[]float32 {
+// If rowDone is not nil, it is called after each row of the result is complete.
+func convolvePlane(planePtr *[]float32, kernel *ConvKernel, width, height int, toPlaneCoords planeExtension, rowDone func()) *[]float32 {
 
 	plane := *planePtr
 	radius := kernel.Radius
 	diameter := radius*2 + 1
+	xOffset, yOffset := radius+kernel.AnchorX, radius+kernel.AnchorY // kernel cell aligned with the output
 	res := make([]float32, width*height)
 
 	// for each pixel of the intensity plane:
@@ -158,16 +387,28 @@ func convolvePlane(planePtr *[]float32, kernel *ConvKernel, width, height int, t
 			// compute convolved value of the pixel:
 			resV := float32(0)
 			for yk := 0; yk < diameter; yk++ {
-				yp := toPlaneCoords(y+yk-radius, height)
+				yp := toPlaneCoords(y+yk-yOffset, height)
+				if yp == OUTSIDE_PLANE {
+					continue // the whole kernel row contributes zero
+				}
 				for xk := 0; xk < diameter; xk++ {
-					xp := toPlaneCoords(x+xk-radius, width)
+					xp := toPlaneCoords(x+xk-xOffset, width)
+					if xp == OUTSIDE_PLANE {
+						continue
+					}
 					planeIndex := yp*width + xp
 					kernelIndex := yk*diameter + xk
+					if kernel.Flipped {
+						kernelIndex = len(kernel.Kernel) - 1 - kernelIndex // rotate by 180 degrees
+					}
 					resV += (plane[planeIndex] * kernel.Kernel[kernelIndex])
 				}
 			}
 			res[index] = resV
 		}
+		if rowDone != nil {
+			rowDone()
+		}
 	}
 
 	return &res
@@ -175,55 +416,206 @@ func convolvePlane(planePtr *[]float32, kernel *ConvKernel, width, height int, t
 
 // Apply a convolution kernel to the image.
 // Creates a new image (does not modify the original).
-func (img *FloatImage) convolve(kernel *ConvKernel, px planeExtension) *FloatImage {
+// Progress (which may be nil) is reported after each row of each plane.
+// Panics with the error from Validate if the kernel is malformed
+// (use the E variants, e.g. ConvolveClampE, to get the error instead).
+func (img *FloatImage) convolve(kernel *ConvKernel, px planeExtension, progress Progress) *FloatImage {
+	if err := kernel.Validate(); err != nil {
+		panic(err)
+	}
 
 	// convolve each plane independently:
 	res := new([3][]float32)
+	rows, totalRows := 0, 3*img.Height
+	rowDone := func() {
+		rows++
+		progress.report(rows, totalRows)
+	}
 	for i := 0; i < 3; i++ {
-		convolvePlane(&img.Ip[i], kernel, img.Width, img.Height, px)
+		res[i] = *convolvePlane(&img.Ip[i], kernel, img.Width, img.Height, px, rowDone)
 	}
 
 	return &FloatImage{
 		Ip:     *res,
 		Width:  img.Width,
 		Height: img.Height,
+		Space:  img.Space,
 	}
 }
 
 // Apply a convolution, in place, to the image.
 // Modifies the current image.
+// Panics with the error from Validate if the kernel is malformed (as per convolve).
 func (img *FloatImage) convolveWith(kernel *ConvKernel, px planeExtension) {
+	if err := kernel.Validate(); err != nil {
+		panic(err)
+	}
 
 	// convolve each plane independently:
 	for i := 0; i < 3; i++ {
-		img.Ip[i] = *convolvePlane(&img.Ip[i], kernel, img.Width, img.Height, px)
+		img.Ip[i] = *convolvePlane(&img.Ip[i], kernel, img.Width, img.Height, px, nil)
 	}
 }
 
 // Apply a convolution kernel to the image, with Edge clamping.
 // Creates a new image (does not modify the original).
+// Panics if the kernel is malformed (see ConvKernel.Validate): ConvolveClampE returns an error instead.
 func (img *FloatImage) ConvolveClamp(kernel *ConvKernel) *FloatImage {
-	return img.convolve(kernel, clampPlaneExtension)
+	return img.convolve(kernel, clampPlaneExtension, nil)
 }
 
 // Apply a convolution kernel to the image, with Edge wrapping.
 // Creates a new image (does not modify the original).
+// Panics if the kernel is malformed (see ConvKernel.Validate): ConvolveWrapE returns an error instead.
 func (img *FloatImage) ConvolveWrap(kernel *ConvKernel) *FloatImage {
-	return img.convolve(kernel, wrapPlaneExtension)
+	return img.convolve(kernel, wrapPlaneExtension, nil)
+}
+
+// Apply a convolution kernel to the image, with Edge reflection (mirroring the image about its edges).
+// This avoids the smearing of edge clamping, and the bleeding in from the opposite edge of edge wrapping.
+// Creates a new image (does not modify the original).
+// Panics if the kernel is malformed: check it with ConvKernel.Validate first, if in doubt.
+func (img *FloatImage) ConvolveReflect(kernel *ConvKernel) *FloatImage {
+	return img.convolve(kernel, reflectPlaneExtension, nil)
+}
+
+// Apply a convolution kernel to the image, treating pixels beyond the edges as zero (black).
+// Unlike clamping, this doesn't repeat the edge pixels, so e.g. an edge detector responds to the
+// image border itself.
+// Creates a new image (does not modify the original).
+// Panics if the kernel is malformed: check it with ConvKernel.Validate first, if in doubt.
+func (img *FloatImage) ConvolveZero(kernel *ConvKernel) *FloatImage {
+	return img.convolve(kernel, zeroPlaneExtension, nil)
+}
+
+// Apply a convolution kernel to the image, with the edges handled as per the given mode
+// (e.g. ConvolveEdge(kernel, EdgeWrap) is the same as ConvolveWrap(kernel)).
+// Creates a new image (does not modify the original).
+// Panics if the kernel is malformed: check it with ConvKernel.Validate first, if in doubt.
+func (img *FloatImage) ConvolveEdge(kernel *ConvKernel, mode EdgeMode) *FloatImage {
+	return img.convolve(kernel, mode.planeExtension(), nil)
+}
+
+// Apply a convolution kernel to the image, with Edge clamping, as per ConvolveClamp,
+// reporting the progress (e.g. for large kernels) as each row is completed.
+// Creates a new image (does not modify the original).
+// Panics if the kernel is malformed (see ConvKernel.Validate).
+func (img *FloatImage) ConvolveClampProgress(kernel *ConvKernel, progress Progress) *FloatImage {
+	return img.convolve(kernel, clampPlaneExtension, progress)
+}
+
+// Apply a convolution kernel to the image, with Edge wrapping, as per ConvolveWrap,
+// reporting the progress (e.g. for large kernels) as each row is completed.
+// Creates a new image (does not modify the original).
+// Panics if the kernel is malformed (see ConvKernel.Validate).
+func (img *FloatImage) ConvolveWrapProgress(kernel *ConvKernel, progress Progress) *FloatImage {
+	return img.convolve(kernel, wrapPlaneExtension, progress)
 }
 
 // Apply a convolution, in place, to the image, with Edge clamping.
 // Modifies the current image.
-func (img *FloatImage) ConvolveClampWith(kernel *ConvKernel, px planeExtension) {
+// Panics if the kernel is malformed (see ConvKernel.Validate): ConvolveClampWithE returns an error instead.
+func (img *FloatImage) ConvolveClampWith(kernel *ConvKernel) {
 	img.convolveWith(kernel, clampPlaneExtension)
 }
 
 // Apply a convolution, in place, to the image, with Edge wrapping.
 // Modifies the current image.
-func (img *FloatImage) ConvolveWrapWith(kernel *ConvKernel, px planeExtension) {
+// Panics if the kernel is malformed (see ConvKernel.Validate): ConvolveWrapWithE returns an error instead.
+func (img *FloatImage) ConvolveWrapWith(kernel *ConvKernel) {
 	img.convolveWith(kernel, wrapPlaneExtension)
 }
 
+// Apply a convolution, in place, to the image, with Edge reflection.
+// Modifies the current image.
+// Panics if the kernel is malformed: check it with ConvKernel.Validate first, if in doubt.
+func (img *FloatImage) ConvolveReflectWith(kernel *ConvKernel) {
+	img.convolveWith(kernel, reflectPlaneExtension)
+}
+
+// Validate checks that the kernel is well-formed: that it has (2*Radius + 1)^2 entries,
+// for a non-negative Radius, and that its anchor lies within the kernel.
+// Returns an error describing the first problem found (or nil, if the kernel is valid).
+// The Convolve methods panic with this error on a malformed kernel: the E variants
+// (e.g. ConvolveClampE) return it instead.
+func (k *ConvKernel) Validate() error {
+	if k == nil {
+		return errors.New("convolution kernel is nil")
+	}
+	if k.Radius < 0 {
+		return fmt.Errorf("convolution kernel has a negative radius: %d", k.Radius)
+	}
+	diameter := k.Radius*2 + 1
+	if len(k.Kernel) != diameter*diameter {
+		return fmt.Errorf("convolution kernel of radius %d must have %d entries, but has %d",
+			k.Radius, diameter*diameter, len(k.Kernel))
+	}
+	if k.AnchorX < -k.Radius || k.AnchorX > k.Radius ||
+		k.AnchorY < -k.Radius || k.AnchorY > k.Radius {
+		return fmt.Errorf("convolution kernel anchor (%d,%d) lies outside the kernel of radius %d",
+			k.AnchorX, k.AnchorY, k.Radius)
+	}
+	return nil
+}
+
+// Check that the kernel and the image are well-formed, so that convolving them cannot panic.
+func (img *FloatImage) checkConvolvable(kernel *ConvKernel) error {
+	if err := kernel.Validate(); err != nil {
+		return err
+	}
+
+	if img.Width <= 0 || img.Height <= 0 {
+		return fmt.Errorf("cannot convolve an empty image (%dx%d)", img.Width, img.Height)
+	}
+	area := img.Width * img.Height
+	for i := 0; i < 3; i++ {
+		if len(img.Ip[i]) != area {
+			return fmt.Errorf("intensity plane %d has %d entries, but the image has %d pixels", i, len(img.Ip[i]), area)
+		}
+	}
+	return nil
+}
+
+// Apply a convolution kernel to the image, with Edge clamping.
+// As per ConvolveClamp, except an error is returned (rather than a panic)
+// if the kernel or the image is malformed.
+func (img *FloatImage) ConvolveClampE(kernel *ConvKernel) (*FloatImage, error) {
+	if err := img.checkConvolvable(kernel); err != nil {
+		return nil, err
+	}
+	return img.ConvolveClamp(kernel), nil
+}
+
+// Apply a convolution kernel to the image, with Edge wrapping.
+// As per ConvolveWrap, except an error is returned (rather than a panic)
+// if the kernel or the image is malformed.
+func (img *FloatImage) ConvolveWrapE(kernel *ConvKernel) (*FloatImage, error) {
+	if err := img.checkConvolvable(kernel); err != nil {
+		return nil, err
+	}
+	return img.ConvolveWrap(kernel), nil
+}
+
+// Apply a convolution, in place, to the image, with Edge clamping.
+// Returns an error (leaving the image unmodified) if the kernel or the image is malformed.
+func (img *FloatImage) ConvolveClampWithE(kernel *ConvKernel) error {
+	if err := img.checkConvolvable(kernel); err != nil {
+		return err
+	}
+	img.convolveWith(kernel, clampPlaneExtension)
+	return nil
+}
+
+// Apply a convolution, in place, to the image, with Edge wrapping.
+// Returns an error (leaving the image unmodified) if the kernel or the image is malformed.
+func (img *FloatImage) ConvolveWrapWithE(kernel *ConvKernel) error {
+	if err := img.checkConvolvable(kernel); err != nil {
+		return err
+	}
+	img.convolveWith(kernel, wrapPlaneExtension)
+	return nil
+}
+
 // a map function which operates on one pixel at a time
 type PixelMap func(vals ...float32) float32
 
@@ -243,7 +635,7 @@ func (img *FloatImage) Apply(mapFn PixelMap, images ...*FloatImage) {
 
 				// copy image pixels into vals
 				vals[0] = img.Ip[layer][index]
-				for i := 0; i < numImages; i++ {
+				for i := 0; i < len(images); i++ {
 					vals[i+1] = images[i].Ip[layer][index]
 				}
 
@@ -262,3 +654,68 @@ func Apply(mapFn PixelMap, images ...*FloatImage) *FloatImage {
 	result.Apply(mapFn, images[1:]...)
 	return result
 }
+
+// Apply a PixelMap over each pixel over all images, as per FloatImage.Apply,
+// except only to the pixels for which the predicate holds (e.g. only to the shadows).
+// The predicate is called once per pixel, with the current image's three plane values
+// for that pixel (before any are modified); other pixels are left unchanged.
+// Modifies the current image.
+// All images must have the same dimensions (this constraint is not checked).
+func (img *FloatImage) ApplyWhere(predicate func(vals ...float32) bool, mapFn PixelMap, images ...*FloatImage) {
+
+	// decide which pixels to apply the map to, before modifying any planes
+	selected := make([]bool, img.Width*img.Height)
+	for index := range selected {
+		selected[index] = predicate(img.Ip[0][index], img.Ip[1][index], img.Ip[2][index])
+	}
+
+	vals := make([]float32, len(images)+1) // + 1 for the current image
+	for layer := 0; layer < 3; layer++ {
+		for index, apply := range selected {
+			if !apply {
+				continue
+			}
+			vals[0] = img.Ip[layer][index]
+			for i, other := range images {
+				vals[i+1] = other.Ip[layer][index]
+			}
+			img.Ip[layer][index] = mapFn(vals...)
+		}
+	}
+}
+
+// Apply a PixelMap over each pixel over all images, as per FloatImage.Apply,
+// except that any of the other images may be 1x1: its single pixel is broadcast,
+// i.e. used for every pixel of the current image (e.g. for subtracting a per-plane constant).
+// Modifies the current image.
+// Returns an error (without modifying the image) if any other image is neither 1x1
+// nor the same size as the current image.
+func (img *FloatImage) ApplyBroadcast(mapFn PixelMap, images ...*FloatImage) error {
+	broadcast := make([]bool, len(images))
+	for i, other := range images {
+		if other == nil {
+			return fmt.Errorf("cannot apply over a nil image (argument %d)", i)
+		}
+		broadcast[i] = other.Width == 1 && other.Height == 1
+		if !broadcast[i] && (other.Width != img.Width || other.Height != img.Height) {
+			return fmt.Errorf("cannot apply over a %dx%d image (argument %d): must be 1x1 or %dx%d",
+				other.Width, other.Height, i, img.Width, img.Height)
+		}
+	}
+
+	vals := make([]float32, len(images)+1) // + 1 for the current image
+	for layer := 0; layer < 3; layer++ {
+		for index := range img.Ip[layer] {
+			vals[0] = img.Ip[layer][index]
+			for i, other := range images {
+				if broadcast[i] {
+					vals[i+1] = other.Ip[layer][0]
+				} else {
+					vals[i+1] = other.Ip[layer][index]
+				}
+			}
+			img.Ip[layer][index] = mapFn(vals...)
+		}
+	}
+	return nil
+}
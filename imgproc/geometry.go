@@ -0,0 +1,147 @@
+// Implements geometric operations: extracting and rearranging regions of an image.
+package imgproc
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Crop returns a new image containing the rectangle [x0,x1) x [y0,y1) of the current image.
+// The new image has dimensions (x1-x0) x (y1-y0).
+// Returns an error if the rectangle is empty, inverted, or extends outside the image.
+func (img *FloatImage) Crop(x0, y0, x1, y1 int) (*FloatImage, error) {
+	if x0 >= x1 || y0 >= y1 {
+		return nil, fmt.Errorf("crop rectangle (%d,%d)-(%d,%d) is empty or inverted", x0, y0, x1, y1)
+	}
+	if x0 < 0 || y0 < 0 || x1 > img.Width || y1 > img.Height {
+		return nil, fmt.Errorf("crop rectangle (%d,%d)-(%d,%d) extends outside the %dx%d image",
+			x0, y0, x1, y1, img.Width, img.Height)
+	}
+
+	width, height := x1-x0, y1-y0
+	res := NewFloatImage(width, height)
+	res.Space = img.Space
+
+	// copy one row at a time, plane by plane
+	for i := 0; i < 3; i++ {
+		for y := 0; y < height; y++ {
+			srcStart := (y+y0)*img.Width + x0
+			copy(res.Ip[i][y*width:(y+1)*width], img.Ip[i][srcStart:srcStart+width])
+		}
+	}
+	return res, nil
+}
+
+// Paste copies the foreground image into the current image, with its top-left corner at (x,y).
+// The foreground is clipped at the edges of the current image, so it may be partially
+// (or entirely) outside; offsets may be negative.
+// As FloatImage has no alpha channel, the pasted pixels overwrite the current ones.
+// Returns an error if the foreground is nil or in a different color space.
+// Modifies the current image.
+func (img *FloatImage) Paste(fg *FloatImage, x, y int) error {
+	if fg == nil {
+		return errors.New("cannot paste a nil image")
+	}
+	if fg.Space != img.Space {
+		return fmt.Errorf("cannot paste an image in color space %d onto one in color space %d", fg.Space, img.Space)
+	}
+
+	// the region of the foreground which overlaps the current image
+	fx0, fy0, fx1, fy1 := 0, 0, fg.Width, fg.Height
+	if x < 0 {
+		fx0 = -x
+	}
+	if y < 0 {
+		fy0 = -y
+	}
+	if x+fx1 > img.Width {
+		fx1 = img.Width - x
+	}
+	if y+fy1 > img.Height {
+		fy1 = img.Height - y
+	}
+	if fx0 >= fx1 || fy0 >= fy1 {
+		return nil // nothing overlaps
+	}
+
+	// copy one row at a time, plane by plane
+	for i := 0; i < 3; i++ {
+		for fy := fy0; fy < fy1; fy++ {
+			dstStart := (fy+y)*img.Width + fx0 + x
+			copy(img.Ip[i][dstStart:dstStart+fx1-fx0], fg.Ip[i][fy*fg.Width+fx0:fy*fg.Width+fx1])
+		}
+	}
+	return nil
+}
+
+// Tile returns a new outWidth x outHeight image, filled by repeating the current image
+// (e.g. for texture backgrounds). The repetition starts at the top-left corner,
+// so partial tiles are placed along the right and bottom edges.
+// An empty image (or output size) gives an empty result.
+func (img *FloatImage) Tile(outWidth, outHeight int) *FloatImage {
+	if outWidth <= 0 || outHeight <= 0 || img.Width <= 0 || img.Height <= 0 {
+		return NewFloatImage(0, 0)
+	}
+
+	res := NewFloatImage(outWidth, outHeight)
+	res.Space = img.Space
+	for i := 0; i < 3; i++ {
+		src, dst := img.Ip[i], res.Ip[i]
+		for y := 0; y < outHeight; y++ {
+			srcRow := src[(y%img.Height)*img.Width : (y%img.Height+1)*img.Width]
+			dstRow := dst[y*outWidth : (y+1)*outWidth]
+			for x := 0; x < outWidth; x += img.Width {
+				copy(dstRow[x:], srcRow) // the last copy is truncated to a partial tile
+			}
+		}
+	}
+	return res
+}
+
+// Mirror the image left-to-right, by swapping columns across all three planes.
+// For an odd width, the center column is unchanged.
+// Modifies the current image.
+func (img *FloatImage) FlipHorizontal() {
+	for i := 0; i < 3; i++ {
+		plane := img.Ip[i]
+		for y := 0; y < img.Height; y++ {
+			row := plane[y*img.Width : (y+1)*img.Width]
+			for l, r := 0, img.Width-1; l < r; l, r = l+1, r-1 {
+				row[l], row[r] = row[r], row[l]
+			}
+		}
+	}
+}
+
+// Mirror the image as per FloatImage.FlipHorizontal, except return a new image
+// rather than modifying the original image.
+func FlipHorizontal(img *FloatImage) *FloatImage {
+	result := img.Clone() // init new image
+	result.FlipHorizontal()
+	return result
+}
+
+// Mirror the image top-to-bottom, by swapping rows across all three planes.
+// For an odd height, the center row is unchanged.
+// Modifies the current image.
+func (img *FloatImage) FlipVertical() {
+	tmp := make([]float32, img.Width)
+	for i := 0; i < 3; i++ {
+		plane := img.Ip[i]
+		for t, b := 0, img.Height-1; t < b; t, b = t+1, b-1 {
+			top := plane[t*img.Width : (t+1)*img.Width]
+			bottom := plane[b*img.Width : (b+1)*img.Width]
+			copy(tmp, top)
+			copy(top, bottom)
+			copy(bottom, tmp)
+		}
+	}
+}
+
+// Mirror the image as per FloatImage.FlipVertical, except return a new image
+// rather than modifying the original image.
+func FlipVertical(img *FloatImage) *FloatImage {
+	result := img.Clone() // init new image
+	result.FlipVertical()
+	return result
+}
@@ -0,0 +1,139 @@
+// Test file for geometry.go
+
+package imgproc
+
+import (
+	"testing"
+)
+
+func TestCropBottomRightQuadrant(t *testing.T) {
+	img := sequentialImage(4, 4)
+	res, err := img.Crop(2, 2, 4, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertIntEquals(t, 2, res.Width, "Crop.Width")
+	assertIntEquals(t, 2, res.Height, "Crop.Height")
+	for i := 0; i < 3; i++ {
+		assertFloat32SliceEquals(t, []float32{10, 11, 14, 15}, res.Ip[i], "Crop[bottom-right]")
+	}
+}
+
+func TestCropNonSquareRegion(t *testing.T) {
+	img := sequentialImage(5, 4)
+	res, err := img.Crop(1, 1, 4, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertFloat32SliceEquals(t, []float32{6, 7, 8, 11, 12, 13}, res.Ip[0], "Crop[3x2]")
+}
+
+func TestCropRejectsInvalidRectangles(t *testing.T) {
+	img := sequentialImage(4, 4)
+	for _, r := range [][4]int{
+		{1, 1, 1, 3},  // empty
+		{3, 1, 1, 3},  // inverted
+		{-1, 0, 2, 2}, // outside (left)
+		{2, 2, 5, 4},  // outside (right)
+		{0, 0, 4, 5},  // outside (bottom)
+	} {
+		_, err := img.Crop(r[0], r[1], r[2], r[3])
+		assert(t, err != nil, "Crop should reject the rectangle")
+	}
+}
+
+func TestFlipHorizontal(t *testing.T) {
+	// 0 1 2
+	// 3 4 5
+	img := sequentialImage(3, 2)
+	res := FlipHorizontal(img)
+	for i := 0; i < 3; i++ {
+		assertFloat32SliceEquals(t, []float32{2, 1, 0, 5, 4, 3}, res.Ip[i], "FlipHorizontal")
+	}
+	assertFloat32SliceEquals(t, []float32{0, 1, 2, 3, 4, 5}, img.Ip[0], "FlipHorizontal.original")
+}
+
+func TestFlipVertical(t *testing.T) {
+	img := sequentialImage(3, 2)
+	img.FlipVertical()
+	for i := 0; i < 3; i++ {
+		assertFloat32SliceEquals(t, []float32{3, 4, 5, 0, 1, 2}, img.Ip[i], "FlipVertical")
+	}
+}
+
+func TestFlipVerticalLeavesCenterRowOfOddHeight(t *testing.T) {
+	img := sequentialImage(2, 3)
+	res := FlipVertical(img)
+	assertFloat32SliceEquals(t, []float32{4, 5, 2, 3, 0, 1}, res.Ip[0], "FlipVertical[odd]")
+}
+
+func TestFlipTwiceIsIdentity(t *testing.T) {
+	img := sequentialImage(5, 3)
+	res := FlipHorizontal(FlipHorizontal(img))
+	assertFloat32SliceEquals(t, img.Ip[1], res.Ip[1], "FlipHorizontal[twice]")
+	res = FlipVertical(FlipVertical(img))
+	assertFloat32SliceEquals(t, img.Ip[1], res.Ip[1], "FlipVertical[twice]")
+}
+
+func TestPasteOverwritesTargetRegionOnly(t *testing.T) {
+	bg := sequentialImage(4, 4)
+	fg := grayImage(2, 2, 100, 101, 102, 103)
+	if err := bg.Paste(fg, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	exp := []float32{
+		0, 1, 2, 3,
+		4, 100, 101, 7,
+		8, 102, 103, 11,
+		12, 13, 14, 15,
+	}
+	assertFloat32SliceEquals(t, exp, bg.Ip[0], "Paste[2x2 at (1,1)]")
+}
+
+func TestPasteClipsAtEdges(t *testing.T) {
+	bg := NewFloatImage(3, 3)
+	fg := grayImage(2, 2, 1, 2, 3, 4)
+	if err := bg.Paste(fg, -1, 2); err != nil {
+		t.Fatal(err)
+	}
+	assertFloat32SliceEquals(t, []float32{0, 0, 0, 0, 0, 0, 2, 0, 0}, bg.Ip[1], "Paste[clipped]")
+
+	// entirely outside: no change
+	if err := bg.Paste(fg, 5, 0); err != nil {
+		t.Fatal(err)
+	}
+	assertFloat32SliceEquals(t, []float32{0, 0, 0, 0, 0, 0, 2, 0, 0}, bg.Ip[1], "Paste[outside]")
+}
+
+func TestPasteRejectsInvalidForeground(t *testing.T) {
+	bg := NewFloatImage(3, 3)
+	assert(t, bg.Paste(nil, 0, 0) != nil, "Paste should reject a nil image")
+
+	fg := NewFloatImage(1, 1)
+	fg.Space = YCrCb
+	assert(t, bg.Paste(fg, 0, 0) != nil, "Paste should reject a mismatched color space")
+}
+
+func TestTileRepeatsWithPartialEdges(t *testing.T) {
+	img := grayImage(2, 2, 1, 2, 3, 4)
+	res := img.Tile(5, 5)
+
+	assertIntEquals(t, 5, res.Width, "Tile.Width")
+	assertIntEquals(t, 5, res.Height, "Tile.Height")
+	exp := []float32{
+		1, 2, 1, 2, 1,
+		3, 4, 3, 4, 3,
+		1, 2, 1, 2, 1,
+		3, 4, 3, 4, 3,
+		1, 2, 1, 2, 1,
+	}
+	for i := 0; i < 3; i++ {
+		assertFloat32SliceEquals(t, exp, res.Ip[i], "Tile[2x2->5x5]")
+	}
+}
+
+func TestTileOfEmptyImage(t *testing.T) {
+	res := NewFloatImage(0, 0).Tile(3, 3)
+	assertIntEquals(t, 0, res.Width, "Tile[empty].Width")
+}
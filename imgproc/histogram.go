@@ -0,0 +1,253 @@
+// Implements histogram based contrast enhancement.
+package imgproc
+
+// number of bins in an intensity histogram: one per 8-bit output level.
+const HISTOGRAM_BINS = 256
+
+// find the histogram bin for an intensity in the [0,65536) range.
+func toBin(v float32) int {
+	return clampPlaneExtension(int(float64(v)/SCALE_CONST), HISTOGRAM_BINS)
+}
+
+// Clip each bin of the histogram at limit, and redistribute the clipped
+// excess evenly over all the bins.
+// Modifies the histogram.
+func clipHistogram(hist []int, limit int) {
+	excess := 0
+	for i, count := range hist {
+		if count > limit {
+			excess += count - limit
+			hist[i] = limit
+		}
+	}
+
+	// spread the excess evenly, with any remainder spaced out across the range
+	numBins := len(hist)
+	share, remainder := excess/numBins, excess%numBins
+	for i := range hist {
+		hist[i] += share
+	}
+	for i := 0; i < remainder; i++ {
+		hist[i*numBins/remainder]++
+	}
+}
+
+// Build a lookup table mapping each histogram bin to an equalized intensity,
+// using the cumulative distribution of the histogram.
+func equalizationMap(hist []int) []float32 {
+	total := 0
+	for _, count := range hist {
+		total += count
+	}
+
+	lut := make([]float32, len(hist))
+	if total == 0 {
+		return lut
+	}
+
+	cumulative := 0
+	for i, count := range hist {
+		cumulative += count
+		lut[i] = INTENSITY_MAX * float32(cumulative) / float32(total)
+	}
+	return lut
+}
+
+// helper function for equalizing a single intensity plane, over the whole image.
+// The lowest occupied bin is mapped to 0 and the highest to 65535, so the result spans the full range.
+func equalizePlane(plane []float32) {
+	hist := make([]int, HISTOGRAM_BINS)
+	for _, v := range plane {
+		hist[toBin(v)]++
+	}
+
+	// count of the lowest occupied bin
+	lowest := 0
+	for _, count := range hist {
+		if count > 0 {
+			lowest = count
+			break
+		}
+	}
+	total := len(plane)
+	if total == lowest {
+		return // a single intensity level: nothing to spread out
+	}
+
+	lut := make([]float32, HISTOGRAM_BINS)
+	cumulative := 0
+	for i, count := range hist {
+		cumulative += count
+		lut[i] = INTENSITY_MAX * float32(cumulative-lowest) / float32(total-lowest)
+	}
+	for i, v := range plane {
+		plane[i] = lut[toBin(v)]
+	}
+}
+
+// Locate the two tile centers surrounding pos.
+// Returns the indices of the two tiles, and the weight of the second tile.
+// Positions outside the outermost centers use the outermost tile only.
+func tileNeighbours(pos float32, centers []float32) (t0, t1 int, weight float32) {
+	last := len(centers) - 1
+	if pos <= centers[0] {
+		return 0, 0, 0
+	} else if pos >= centers[last] {
+		return last, last, 0
+	}
+
+	for t0 = 0; centers[t0+1] < pos; t0++ {
+	}
+	t1 = t0 + 1
+	weight = (pos - centers[t0]) / (centers[t1] - centers[t0])
+	return
+}
+
+// helper function for applying CLAHE to a single intensity plane.
+func clahePlane(plane []float32, width, height, tiles int, clipLimit float64) {
+
+	// tile boundaries and centers, in each dimension
+	xBounds, yBounds := make([]int, tiles+1), make([]int, tiles+1)
+	xCenters, yCenters := make([]float32, tiles), make([]float32, tiles)
+	for t := 0; t <= tiles; t++ {
+		xBounds[t], yBounds[t] = t*width/tiles, t*height/tiles
+	}
+	for t := 0; t < tiles; t++ {
+		xCenters[t] = float32(xBounds[t]+xBounds[t+1]-1) / 2
+		yCenters[t] = float32(yBounds[t]+yBounds[t+1]-1) / 2
+	}
+
+	// build the clipped, equalized mapping of each tile
+	luts := make([][]float32, tiles*tiles)
+	for ty := 0; ty < tiles; ty++ {
+		for tx := 0; tx < tiles; tx++ {
+			hist := make([]int, HISTOGRAM_BINS)
+			for y := yBounds[ty]; y < yBounds[ty+1]; y++ {
+				for x := xBounds[tx]; x < xBounds[tx+1]; x++ {
+					hist[toBin(plane[y*width+x])]++
+				}
+			}
+
+			if clipLimit > 0 {
+				area := (xBounds[tx+1] - xBounds[tx]) * (yBounds[ty+1] - yBounds[ty])
+				limit := int(clipLimit * float64(area) / HISTOGRAM_BINS)
+				if limit < 1 {
+					limit = 1
+				}
+				clipHistogram(hist, limit)
+			}
+			luts[ty*tiles+tx] = equalizationMap(hist)
+		}
+	}
+
+	// remap each pixel, interpolating between the four nearest tile mappings
+	for y := 0; y < height; y++ {
+		ty0, ty1, wy := tileNeighbours(float32(y), yCenters)
+		for x := 0; x < width; x++ {
+			tx0, tx1, wx := tileNeighbours(float32(x), xCenters)
+			index := y*width + x
+			bin := toBin(plane[index])
+
+			top := (1-wx)*luts[ty0*tiles+tx0][bin] + wx*luts[ty0*tiles+tx1][bin]
+			bottom := (1-wx)*luts[ty1*tiles+tx0][bin] + wx*luts[ty1*tiles+tx1][bin]
+			plane[index] = (1-wy)*top + wy*bottom
+		}
+	}
+}
+
+// Apply Contrast-Limited Adaptive Histogram Equalization (CLAHE).
+// The image is divided into a grid of tiles x tiles regions, each equalized
+// using its own histogram. Each histogram is clipped at clipLimit times the
+// mean bin count, which stops noise in flat regions being over-amplified.
+// (A clipLimit <= 0 disables the clipping).
+// The tile mappings are bilinearly interpolated between tile centers to avoid block artifacts.
+// (A YCrCb or HSV image is equalized in RGB, then converted back).
+// Modifies the current image.
+func (img *FloatImage) CLAHE(tiles int, clipLimit float64) {
+	switch img.Space {
+	case YCrCb:
+		img.ToRGB()
+		defer img.ToYCrCb()
+	case HSV:
+		img.ToRGB()
+		defer img.ToHSV()
+	}
+
+	// each tile must contain at least one pixel
+	if tiles < 1 {
+		tiles = 1
+	}
+	if tiles > img.Width {
+		tiles = img.Width
+	}
+	if tiles > img.Height {
+		tiles = img.Height
+	}
+	if tiles < 1 {
+		return // empty image
+	}
+
+	for i := 0; i < 3; i++ {
+		clahePlane(img.Ip[i], img.Width, img.Height, tiles, clipLimit)
+	}
+}
+
+// Apply CLAHE as per FloatImage.CLAHE, except return a new image
+// rather than modifying the original image.
+func CLAHE(img *FloatImage, tiles int, clipLimit float64) *FloatImage {
+	result := img.Clone() // init new image
+	result.CLAHE(tiles, clipLimit)
+	return result
+}
+
+// Apply histogram equalization to each of the three planes independently:
+// intensities are remapped by their cumulative distribution, so that they
+// spread across the full [0,65535] range. (A YCrCb or HSV image is equalized in RGB).
+// Equalizing the RGB planes independently can shift the colors: see EqualizeLuma.
+// Modifies the current image.
+func (img *FloatImage) EqualizeHistogram() {
+	switch img.Space {
+	case YCrCb:
+		img.ToRGB()
+		defer img.ToYCrCb()
+	case HSV:
+		img.ToRGB()
+		defer img.ToHSV()
+	}
+
+	for i := 0; i < 3; i++ {
+		equalizePlane(img.Ip[i])
+	}
+}
+
+// Apply histogram equalization as per FloatImage.EqualizeHistogram, except return a new image
+// rather than modifying the original image.
+func EqualizeHistogram(img *FloatImage) *FloatImage {
+	result := img.Clone() // init new image
+	result.EqualizeHistogram()
+	return result
+}
+
+// Apply histogram equalization to the luma only, leaving the chroma alone,
+// so that the contrast is increased without shifting the colors.
+// The image is equalized in YCrCb, then converted back to its original color space.
+// Modifies the current image.
+func (img *FloatImage) EqualizeLuma() {
+	switch img.Space {
+	case RGB:
+		defer img.ToRGB()
+	case HSV:
+		defer img.ToHSV()
+	}
+
+	img.ToYCrCb()
+	equalizePlane(img.Ip[0])
+}
+
+// Apply luma histogram equalization as per FloatImage.EqualizeLuma, except return a new image
+// rather than modifying the original image.
+func EqualizeLuma(img *FloatImage) *FloatImage {
+	result := img.Clone() // init new image
+	result.EqualizeLuma()
+	return result
+}
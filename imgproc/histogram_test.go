@@ -0,0 +1,168 @@
+// Test file for histogram.go
+
+package imgproc
+
+import (
+	"fmt"
+	"testing"
+)
+
+// build a gray image with a shallow horizontal ramp: intensity rises one
+// 8-bit level every two columns, starting from level 100.
+func shallowRampImage(width, height int) *FloatImage {
+	img := NewFloatImage(width, height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := float32(SCALE_CONST) * float32(100+x/2)
+			for i := 0; i < 3; i++ {
+				img.Ip[i][y*width+x] = v
+			}
+		}
+	}
+	return img
+}
+
+// range of intensities within columns [x0,x1) of a plane
+func columnRange(plane []float32, width, height, x0, x1 int) float32 {
+	lo, hi := plane[x0], plane[x0]
+	for y := 0; y < height; y++ {
+		for x := x0; x < x1; x++ {
+			v := plane[y*width+x]
+			if v < lo {
+				lo = v
+			} else if v > hi {
+				hi = v
+			}
+		}
+	}
+	return hi - lo
+}
+
+// largest jump in intensity between horizontally adjacent pixels of a plane
+func maxHorizontalStep(plane []float32, width, height int) float32 {
+	maxStep := float32(0)
+	for y := 0; y < height; y++ {
+		for x := 1; x < width; x++ {
+			step := plane[y*width+x] - plane[y*width+x-1]
+			if step < 0 {
+				step = -step
+			}
+			if step > maxStep {
+				maxStep = step
+			}
+		}
+	}
+	return maxStep
+}
+
+func TestClipHistogramPreservesTotal(t *testing.T) {
+	hist := make([]int, HISTOGRAM_BINS)
+	hist[10], hist[20] = 1000, 3
+	clipHistogram(hist, 50)
+
+	total := 0
+	for _, count := range hist {
+		total += count
+	}
+	assertIntEquals(t, 1003, total, "clipHistogram.total")
+	assert(t, hist[10] < 1000, "clipHistogram should clip the tallest bin")
+}
+
+func TestCLAHEIncreasesLocalContrast(t *testing.T) {
+	width, height, tiles := 64, 32, 4
+	orig := shallowRampImage(width, height)
+	res := CLAHE(orig, tiles, 4)
+
+	tileWidth := width / tiles
+	for tx := 0; tx < tiles; tx++ {
+		x0, x1 := tx*tileWidth, (tx+1)*tileWidth
+		before := columnRange(orig.Ip[0], width, height, x0, x1)
+		after := columnRange(res.Ip[0], width, height, x0, x1)
+		assert(t, after > before, fmt.Sprintf("CLAHE[tile=%d]: contrast should increase: before=%f, after=%f", tx, before, after))
+	}
+}
+
+func TestCLAHEAvoidsTileSeams(t *testing.T) {
+	width, height, tiles := 64, 32, 4
+	orig := shallowRampImage(width, height)
+	res := CLAHE(orig, tiles, 4)
+
+	// naive tiled equalization: each tile remapped with its own (clipped) histogram only
+	naive := orig.Clone()
+	plane, tileWidth := naive.Ip[0], width/tiles
+	for tx := 0; tx < tiles; tx++ {
+		hist := make([]int, HISTOGRAM_BINS)
+		for y := 0; y < height; y++ {
+			for x := tx * tileWidth; x < (tx+1)*tileWidth; x++ {
+				hist[toBin(plane[y*width+x])]++
+			}
+		}
+		clipHistogram(hist, 4*tileWidth*height/HISTOGRAM_BINS)
+		lut := equalizationMap(hist)
+		for y := 0; y < height; y++ {
+			for x := tx * tileWidth; x < (tx+1)*tileWidth; x++ {
+				plane[y*width+x] = lut[toBin(plane[y*width+x])]
+			}
+		}
+	}
+
+	claheStep := maxHorizontalStep(res.Ip[0], width, height)
+	naiveStep := maxHorizontalStep(naive.Ip[0], width, height)
+	assert(t, claheStep*2 < naiveStep, fmt.Sprintf("CLAHE should not produce seams: clahe-step=%f, naive-step=%f", claheStep, naiveStep))
+}
+
+func TestCLAHEDoesNotModifyOriginal(t *testing.T) {
+	orig := shallowRampImage(8, 8)
+	copied := orig.Clone()
+	CLAHE(orig, 2, 2)
+	assertFloat32SliceEquals(t, copied.Ip[0], orig.Ip[0], "CLAHE.original")
+}
+
+func TestCLAHEOfNonRGBImageEqualizesInRGB(t *testing.T) {
+	assertAdjustsInRGB(t, func(img *FloatImage) { img.CLAHE(2, 2) }, "CLAHE")
+}
+
+func TestEqualizeHistogramWidensLowContrastGradient(t *testing.T) {
+	width, height := 64, 4
+	orig := shallowRampImage(width, height)
+	res := EqualizeHistogram(orig)
+
+	before := columnRange(orig.Ip[0], width, height, 0, width)
+	after := columnRange(res.Ip[0], width, height, 0, width)
+	assert(t, after > 4*before, fmt.Sprintf("EqualizeHistogram: range should widen: before=%f, after=%f", before, after))
+	assertFloat32Equals(t, 0, res.Ip[0][0], "EqualizeHistogram.darkest")
+	assertFloat32Equals(t, INTENSITY_MAX, res.Ip[0][width-1], "EqualizeHistogram.brightest")
+}
+
+func TestEqualizeHistogramOfUniformHistogramIsUnchanged(t *testing.T) {
+	// one pixel in each bin, at each 8-bit level as decoded (i.e. 257x, as per color.RGBA)
+	img := NewFloatImage(HISTOGRAM_BINS, 1)
+	for i := 0; i < 3; i++ {
+		for x := range img.Ip[i] {
+			img.Ip[i][x] = float32(x * 257)
+		}
+	}
+	res := EqualizeHistogram(img)
+	for x := 0; x < img.Width; x++ {
+		assert(t, res.At(x, 0) == img.At(x, 0), fmt.Sprintf("EqualizeHistogram[uniform][%d]: exp=%v, act=%v", x, img.At(x, 0), res.At(x, 0)))
+	}
+}
+
+func TestEqualizeLumaKeepsChroma(t *testing.T) {
+	width, height := 64, 4
+	orig := shallowRampImage(width, height)
+	for i := range orig.Ip[2] {
+		orig.Ip[2][i] *= 0.5 // tint, so the chroma isn't neutral
+	}
+	res := EqualizeLuma(orig)
+	assert(t, res.Space == RGB, "EqualizeLuma should keep the color space")
+
+	before, after := orig.Clone(), res.Clone()
+	before.ToYCrCb()
+	after.ToYCrCb()
+	for i := 1; i < 3; i++ {
+		assertPlanesClose(t, before.Ip[i], after.Ip[i], 1, fmt.Sprintf("EqualizeLuma.chroma[%d]", i))
+	}
+	assert(t, columnRange(after.Ip[0], width, height, 0, width) > 4*columnRange(before.Ip[0], width, height, 0, width),
+		"EqualizeLuma should widen the luma range")
+}
@@ -0,0 +1,131 @@
+// Implements metrics for comparing images.
+package imgproc
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// build the luma plane of an image, normalized into the range [0,1].
+func normalizedLumaPlane(img *FloatImage) []float32 {
+	luma := make([]float32, img.Width*img.Height)
+	for i := range luma {
+		luma[i] = luminance(img.rgbAt(i)) / INTENSITY_MAX
+	}
+	return luma
+}
+
+// check that two images can be compared pixel-for-pixel.
+func checkComparable(a, b *FloatImage) error {
+	if a == nil || b == nil {
+		return errors.New("cannot compare a nil image")
+	}
+	if a.Width != b.Width || a.Height != b.Height {
+		return fmt.Errorf("cannot compare images of different sizes: %dx%d and %dx%d", a.Width, a.Height, b.Width, b.Height)
+	}
+	if a.Width <= 0 || a.Height <= 0 {
+		return fmt.Errorf("cannot compare empty images (%dx%d)", a.Width, a.Height)
+	}
+	return nil
+}
+
+// window used by SSIM: an 11x11 Gaussian with sigma 1.5, as per
+// Wang et al. (2004), "Image quality assessment: from error visibility to structural similarity".
+const (
+	SSIM_RADIUS   = 5
+	SSIM_VARIANCE = 1.5 * 1.5
+)
+
+// SSIM computes the mean Structural Similarity Index of two images, over their luma planes.
+// The local mean, variance and covariance are computed over a sliding (Gaussian) window, and
+// combined using the standard constants (K1=0.01, K2=0.03).
+// Returns 1 for identical images, and smaller values (down to -1) as the images differ in structure.
+// The images must have the same dimensions.
+func SSIM(a, b *FloatImage) (float64, error) {
+	if err := checkComparable(a, b); err != nil {
+		return 0, err
+	}
+
+	// products of the luma planes, needed for the (co)variances
+	lumaA, lumaB := normalizedLumaPlane(a), normalizedLumaPlane(b)
+	area := len(lumaA)
+	sqA, sqB, prodAB := make([]float32, area), make([]float32, area), make([]float32, area)
+	for i := 0; i < area; i++ {
+		sqA[i], sqB[i], prodAB[i] = lumaA[i]*lumaA[i], lumaB[i]*lumaB[i], lumaA[i]*lumaB[i]
+	}
+
+	// local (windowed) averages
+	window := GaussianFilterKernel(SSIM_RADIUS, SSIM_VARIANCE)
+	average := func(plane []float32) []float32 {
+		return *convolvePlane(&plane, window, a.Width, a.Height, clampPlaneExtension, nil)
+	}
+	meanA, meanB := average(lumaA), average(lumaB)
+	meanSqA, meanSqB, meanProdAB := average(sqA), average(sqB), average(prodAB)
+
+	// stabilizing constants, for a dynamic range of 1
+	c1, c2 := 0.01*0.01, 0.03*0.03
+
+	sum := float64(0)
+	for i := 0; i < area; i++ {
+		muA, muB := float64(meanA[i]), float64(meanB[i])
+		varA := float64(meanSqA[i]) - muA*muA
+		varB := float64(meanSqB[i]) - muB*muB
+		covAB := float64(meanProdAB[i]) - muA*muB
+		sum += ((2*muA*muB + c1) * (2*covAB + c2)) /
+			((muA*muA + muB*muB + c1) * (varA + varB + c2))
+	}
+
+	// guard against the float rounding taking identical images just past 1
+	return math.Min(sum/float64(area), 1), nil
+}
+
+// PSNR computes the Peak Signal-to-Noise Ratio (in dB) of two images, over their RGB planes.
+// Higher values mean the images are closer: identical images give +Inf.
+// The images must have the same dimensions.
+func PSNR(a, b *FloatImage) (float64, error) {
+	if err := checkComparable(a, b); err != nil {
+		return 0, err
+	}
+
+	// mean squared error, for a dynamic range of 1
+	area := a.Width * a.Height
+	sum := float64(0)
+	for i := 0; i < area; i++ {
+		rA, gA, bA := a.rgbAt(i)
+		rB, gB, bB := b.rgbAt(i)
+		for _, d := range [3]float32{rA - rB, gA - gB, bA - bB} {
+			e := float64(d / INTENSITY_MAX)
+			sum += e * e
+		}
+	}
+	mse := sum / float64(3*area)
+
+	if mse == 0 {
+		return math.Inf(1), nil
+	}
+	return 10 * math.Log10(1/mse), nil
+}
+
+// AbsDiff computes the absolute difference of two images, per pixel and per RGB plane,
+// multiplied by gain (e.g. to make small differences visible), and clamped to [0,65535].
+// Identical images give an all-black result.
+// The images must have the same dimensions. Returns a new (RGB) image.
+func AbsDiff(a, b *FloatImage, gain float32) (*FloatImage, error) {
+	if err := checkComparable(a, b); err != nil {
+		return nil, err
+	}
+
+	res := NewFloatImage(a.Width, a.Height)
+	for i := 0; i < a.Width*a.Height; i++ {
+		rA, gA, bA := a.rgbAt(i)
+		rB, gB, bB := b.rgbAt(i)
+		for p, d := range [3]float32{rA - rB, gA - gB, bA - bB} {
+			if d < 0 {
+				d = -d
+			}
+			res.Ip[p][i] = clampIntensity(gain * d)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,92 @@
+// Test file for metrics.go
+
+package imgproc
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+// build a gray checkerboard, alternating between black and white every cellSize pixels.
+func checkerboardImage(width, height, cellSize int) *FloatImage {
+	img := NewFloatImage(width, height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if (x/cellSize+y/cellSize)%2 == 1 {
+				for i := 0; i < 3; i++ {
+					img.Ip[i][y*width+x] = INTENSITY_MAX
+				}
+			}
+		}
+	}
+	return img
+}
+
+func TestSSIMOfIdenticalImagesIsOne(t *testing.T) {
+	img := checkerboardImage(16, 16, 2)
+	ssim, err := SSIM(img, img.Clone())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, math.Abs(ssim-1) < 1e-4, fmt.Sprintf("SSIM of identical images: exp=1, act=%f", ssim))
+}
+
+func TestSSIMOfBlurredImageIsBelowOne(t *testing.T) {
+	img := checkerboardImage(16, 16, 2)
+	blurred := img.ConvolveClamp(GaussianFilterKernel(2, 2.0))
+	ssim, err := SSIM(img, blurred)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, ssim < 0.9, fmt.Sprintf("SSIM of blurred image should be well below 1, act=%f", ssim))
+}
+
+func TestSSIMRejectsMismatchedSizes(t *testing.T) {
+	_, err := SSIM(NewFloatImage(4, 4), NewFloatImage(4, 5))
+	assert(t, err != nil, "SSIM should reject images of different sizes")
+}
+
+func TestPSNROfIdenticalImagesIsInfinite(t *testing.T) {
+	img := checkerboardImage(8, 8, 2)
+	psnr, err := PSNR(img, img.Clone())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, math.IsInf(psnr, 1), fmt.Sprintf("PSNR of identical images: exp=+Inf, act=%f", psnr))
+}
+
+func TestPSNROfUniformError(t *testing.T) {
+	// every pixel off by a tenth of the range: MSE = 0.01, so PSNR = 20dB
+	a, b := NewFloatImage(4, 4), NewFloatImage(4, 4)
+	for i := 0; i < 3; i++ {
+		for j := range b.Ip[i] {
+			b.Ip[i][j] = INTENSITY_MAX / 10
+		}
+	}
+	psnr, err := PSNR(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, math.Abs(psnr-20) < 1e-3, fmt.Sprintf("PSNR: exp=20, act=%f", psnr))
+}
+
+func TestAbsDiffAmplifiesAndClamps(t *testing.T) {
+	a, b := NewFloatImage(2, 1), NewFloatImage(2, 1)
+	a.Ip[0][0], b.Ip[0][0] = 1000, 1500       // small difference: amplified
+	a.Ip[1][1], b.Ip[1][1] = INTENSITY_MAX, 0 // large difference: clamped
+	diff, err := AbsDiff(a, b, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertFloat32SliceEquals(t, []float32{2000, 0}, diff.Ip[0], "AbsDiff[0]")
+	assertFloat32SliceEquals(t, []float32{0, INTENSITY_MAX}, diff.Ip[1], "AbsDiff[1]")
+	assertFloat32SliceEquals(t, []float32{0, 0}, diff.Ip[2], "AbsDiff[2]")
+}
+
+func TestPSNRAndAbsDiffRejectMismatchedSizes(t *testing.T) {
+	_, err := PSNR(NewFloatImage(4, 4), NewFloatImage(5, 4))
+	assert(t, err != nil, "PSNR should reject images of different sizes")
+	_, err = AbsDiff(NewFloatImage(4, 4), NewFloatImage(5, 4), 1)
+	assert(t, err != nil, "AbsDiff should reject images of different sizes")
+}
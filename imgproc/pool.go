@@ -0,0 +1,69 @@
+// Implements a pool of FloatImages, for reducing allocations (and GC pressure) in long-running servers.
+package imgproc
+
+import "sync"
+
+// the maximum number of distinct image sizes pooled at once (see ReleaseFloatImage).
+const MAX_POOLED_SIZES = 64
+
+// one pool per image size, since only images of matching dimensions can be reused.
+var imagePools = struct {
+	sync.Mutex
+	bySize map[imageSize]*sync.Pool
+}{bySize: make(map[imageSize]*sync.Pool)}
+
+// dimensions of an image, used as the pool key
+type imageSize struct {
+	width, height int
+}
+
+// find (or create) the pool for images of the given dimensions.
+// If MAX_POOLED_SIZES pools already exist, an arbitrary one is dropped to make room,
+// so that the number of pools stays bounded however many distinct sizes are seen.
+func poolFor(width, height int) *sync.Pool {
+	imagePools.Lock()
+	defer imagePools.Unlock()
+
+	key := imageSize{width, height}
+	pool, found := imagePools.bySize[key]
+	if !found {
+		if len(imagePools.bySize) >= MAX_POOLED_SIZES {
+			for evicted := range imagePools.bySize {
+				delete(imagePools.bySize, evicted) // its pooled images are left to the GC
+				break
+			}
+		}
+		pool = &sync.Pool{New: func() interface{} { return NewFloatImage(width, height) }}
+		imagePools.bySize[key] = pool
+	}
+	return pool
+}
+
+// AcquireFloatImage returns a FloatImage of the specified dimensions, with all pixels zero'd,
+// reusing a previously released image of the same dimensions where possible.
+// Behaves as NewFloatImage, except that the image should be passed to ReleaseFloatImage once no longer needed.
+func AcquireFloatImage(width, height int) *FloatImage {
+	img := poolFor(width, height).Get().(*FloatImage)
+	img.Space = RGB
+	for i := 0; i < 3; i++ {
+		plane := img.Ip[i]
+		for j := range plane {
+			plane[j] = 0
+		}
+	}
+	return img
+}
+
+// ReleaseFloatImage returns an image to the pool, so that its planes can be reused by AcquireFloatImage.
+// The image must not be used after it is released: in particular, do not release an image
+// which is still referenced elsewhere (e.g. by an encoder, or as the input of another operation),
+// as its planes will be overwritten when the image is next acquired.
+// At most MAX_POOLED_SIZES distinct sizes are pooled at once, so the pools stay bounded however many
+// different sizes are seen (e.g. by a server fed arbitrary images): beyond that, pooling a new size
+// drops the pool of another, so reuse becomes less likely.
+func ReleaseFloatImage(img *FloatImage) {
+	if img == nil {
+		return
+	}
+	poolFor(img.Width, img.Height).Put(img)
+}
@@ -0,0 +1,69 @@
+// Test file for pool.go
+
+package imgproc
+
+import (
+	"testing"
+)
+
+func TestAcquireAfterReleaseIsZeroed(t *testing.T) {
+	img := AcquireFloatImage(4, 3)
+	for i := 0; i < 3; i++ {
+		for j := range img.Ip[i] {
+			img.Ip[i][j] = float32(j + 1)
+		}
+	}
+	ReleaseFloatImage(img)
+
+	// whether or not the pool hands back the same image, it must be blank and correctly sized
+	res := AcquireFloatImage(4, 3)
+	assertIntEquals(t, 4, res.Width, "AcquireFloatImage.Width")
+	assertIntEquals(t, 3, res.Height, "AcquireFloatImage.Height")
+	for i := 0; i < 3; i++ {
+		assertFloat32SliceEquals(t, make([]float32, 12), res.Ip[i], "AcquireFloatImage.Ip")
+	}
+}
+
+func TestAcquireDoesNotMixSizes(t *testing.T) {
+	ReleaseFloatImage(NewFloatImage(5, 5))
+	res := AcquireFloatImage(2, 8)
+	assertIntEquals(t, 2, res.Width, "AcquireFloatImage.Width")
+	assertIntEquals(t, 8, res.Height, "AcquireFloatImage.Height")
+	assertIntEquals(t, 16, len(res.Ip[0]), "AcquireFloatImage.len(Ip)")
+}
+
+func TestPooledSizesAreBounded(t *testing.T) {
+	for w := 1; w <= 3*MAX_POOLED_SIZES; w++ {
+		ReleaseFloatImage(AcquireFloatImage(w, 2))
+	}
+	imagePools.Lock()
+	pools := len(imagePools.bySize)
+	imagePools.Unlock()
+	assert(t, pools <= MAX_POOLED_SIZES, "the number of pooled sizes should be bounded")
+
+	// evicted sizes are still acquired correctly
+	res := AcquireFloatImage(1, 2)
+	assertIntEquals(t, 2, len(res.Ip[0]), "AcquireFloatImage.len(Ip)")
+}
+
+func TestReleaseNilIsIgnored(t *testing.T) {
+	ReleaseFloatImage(nil) // must not panic
+}
+
+// simulated request load: each request needs a scratch image, then discards it.
+func BenchmarkNewFloatImage(b *testing.B) {
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		img := NewFloatImage(256, 256)
+		img.Ip[0][0] = 1
+	}
+}
+
+func BenchmarkAcquireReleaseFloatImage(b *testing.B) {
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		img := AcquireFloatImage(256, 256)
+		img.Ip[0][0] = 1
+		ReleaseFloatImage(img)
+	}
+}
@@ -1,5 +1,8 @@
+// Implements resampling (i.e. resizing) of images, and the interpolation methods it uses.
 package imgproc
 
+import "math"
+
 // Lerp linearly interpolates between two values, at x0 and x2.
 // The value at x0 is f0 (short for "f(x0)") and the value at x2 is f2.
 // Lerp requires that x0 <= x1 <= x2 and x0 < x2.
@@ -18,34 +21,60 @@ func lerp(x0, x1, x2, f0, f2 float32) float32 {
 func bilerp(x0, x1, x2, y0, y1, y2, f00, f02, f20, f22 float32) float32 {
 	// lerp in x-dir
 	f10 := lerp(x0, x1, x2, f00, f20)
-	f12 := lerp(x0, x1, x2, f20, f22)
+	f12 := lerp(x0, x1, x2, f02, f22)
 	// then, lerp in y-dir
 	return lerp(y0, y1, y2, f10, f12)
 }
 
-// CubicInterpolation interpolates a point (x2) in a single dimension, using cubic interpolation.
+// A CubicFilter is a member of the Mitchell-Netravali family of cubic filters,
+// selected by its B and C parameters. B controls the blurring, and C the ringing.
+// Reference: D. Mitchell and A. Netravali, (1988).
+// "Reconstruction filters in computer graphics". SIGGRAPH Computer Graphics 22 (4).
+type CubicFilter struct {
+	B, C float32
+}
+
+// commonly used cubic filters
+var (
+	// Catmull-Rom (B=0, C=0.5): sharp, and passes through the sample points.
+	// This is the Keys cubic convolution (with a=-0.5). Reference: R. Keys, (1981).
+	// "Cubic convolution interpolation for digital image processing".
+	// IEEE Transactions on Signal Processing, Acoustics, Speech, and Signal Processing
+	CatmullRom = CubicFilter{B: 0, C: 0.5}
+
+	// Mitchell (B=C=1/3): the compromise between blurring and ringing recommended by Mitchell & Netravali.
+	Mitchell = CubicFilter{B: 1.0 / 3, C: 1.0 / 3}
+
+	// cubic B-spline (B=1, C=0): smooth, never rings, but blurs.
+	BSpline = CubicFilter{B: 1, C: 0}
+)
+
+// evaluate the filter kernel at (signed) distance x from a sample point.
+func (f CubicFilter) weight(x float32) float32 {
+	if x < 0 {
+		x = -x
+	}
+	b, c := f.B, f.C
+	switch {
+	case x < 1:
+		return ((12-9*b-6*c)*x*x*x + (-18+12*b+6*c)*x*x + (6 - 2*b)) / 6
+	case x < 2:
+		return ((-b-6*c)*x*x*x + (6*b+30*c)*x*x + (-12*b-48*c)*x + (8*b + 24*c)) / 6
+	}
+	return 0
+}
+
+// CubicInterpolation interpolates a point (x2) in a single dimension, using the given cubic filter.
 // Requires x0 < x1 <= x2 <= x3 < x4, with x4 - x3 = x3 - x1 = x1 - x0 > 0.
 // (I.e. x0,x1,x3,x4 must be 4 distinct, equally spaced points. x2 must lie in between x1 and x3).
-// The values of the points are f0 (short for "f(x0)"), f1, f3, and f4. 
+// The values of the points are f0 (short for "f(x0)"), f1, f3, and f4.
 // CubicInterpolation computes the value of x2, i.e. returns f2.
-func cubicInterpolation(x0, x1, x2, x3, x4, f0, f1, f3, f4 float32) float32 {
+func cubicInterpolation(x0, x1, x2, x3, x4, f0, f1, f3, f4 float32, filter CubicFilter) float32 {
 	// compute where x2 lies in between x1 and x3:
 	t := (x2 - x1) / (x3 - x1)
 
-	// use precomputed formula.
-	// Wikipedia [http://en.wikipedia.org/wiki/Bicubic_interpolation#Bicubic_convolution_algorithm], 
-	// Reference: 
-	//  R. Keys, (1981). 
-	//  "Cubic convolution interpolation for digital image processing". 
-	//  IEEE Transactions on Signal Processing, Acoustics, Speech, and Signal Processing
-	res := 3*(f1-f3) - f0 + f4 // t^3 coef (short for "coefficient")
-	res *= t
-	res += 2*f0 - 5*f1 + 4*f3 - f4 // t^2 coef
-	res *= t
-	res += f3 - f0 // t coef
-	res *= float32(0.5) * t
-	res += f1 // const coef
-	return res
+	// weight each point by the filter, at its distance from x2 (in units of the point spacing)
+	return f0*filter.weight(1+t) + f1*filter.weight(t) + f3*filter.weight(1-t) + f4*filter.weight(2-t)
 }
 
 // BicubicInterpolation interpolates a point (x2,y2) using a 4x4 grid (i.e. of 16 points)
@@ -53,6 +82,7 @@ func cubicInterpolation(x0, x1, x2, x3, x4, f0, f1, f3, f4 float32) float32 {
 // (I.e. x0,x1,x3,x4 must be 4 distinct, equally spaced points. x2 must lie in between x1 and x3).
 // Similarly, for y: y0 < y1 <= y2 <= y3 < y4 with y4 - y4 = y3 - y1 = y1 - y0 > 0.
 // The values of the points are e.g. f00, which is short for "f(x0,y0)".
+// The points are interpolated using the given cubic filter.
 // BicubicInterpolation computes the value of the (x2,y2) point, i.e. returns f22.
 func bicubicInterpolation(
 	x0, x1, x2, x3, x4,
@@ -60,14 +90,361 @@ func bicubicInterpolation(
 	f00, f01, f03, f04,
 	f10, f11, f13, f14,
 	f30, f31, f33, f34,
-	f40, f41, f43, f44 float32) float32 {
+	f40, f41, f43, f44 float32,
+	filter CubicFilter) float32 {
 
 	// interpolate in x-dir:
-	f20 := cubicInterpolation(x0, x1, x2, x3, x4, f00, f10, f30, f40)
-	f21 := cubicInterpolation(x0, x1, x2, x3, x4, f01, f11, f31, f41)
-	f23 := cubicInterpolation(x0, x1, x2, x3, x4, f03, f13, f33, f43)
-	f24 := cubicInterpolation(x0, x1, x2, x3, x4, f04, f14, f34, f44)
+	f20 := cubicInterpolation(x0, x1, x2, x3, x4, f00, f10, f30, f40, filter)
+	f21 := cubicInterpolation(x0, x1, x2, x3, x4, f01, f11, f31, f41, filter)
+	f23 := cubicInterpolation(x0, x1, x2, x3, x4, f03, f13, f33, f43, filter)
+	f24 := cubicInterpolation(x0, x1, x2, x3, x4, f04, f14, f34, f44, filter)
 
 	// then, interpolate in the y-dir:
-	return cubicInterpolation(y0, y1, y2, y3, y4, f20, f21, f23, f24)
+	return cubicInterpolation(y0, y1, y2, y3, y4, f20, f21, f23, f24, filter)
+}
+
+// CoordMode selects how the destination pixels of a resize are mapped onto the source image.
+// Libraries disagree on this, so the mode can be chosen to match a reference implementation.
+type CoordMode int
+
+const (
+	// CenterAligned treats pixels as unit squares and aligns the edges of the two images,
+	// so that each pixel center maps to the center of the matching source area.
+	// This is the recommended mode (as used by e.g. OpenCV and Pillow).
+	CenterAligned CoordMode = iota
+
+	// CornerAligned maps the centers of the corner pixels of the two images onto each other,
+	// and spaces the remaining pixels evenly between them (as with align_corners in TensorFlow).
+	CornerAligned
+)
+
+// Map a destination pixel onto the source coordinate of its center,
+// for a resize from srcSize to dstSize pixels (in one dimension).
+// With CenterAligned, the centers of the edge pixels map onto each other
+// only when no scaling takes place; with CornerAligned, they always do.
+func sourceCoord(dst, srcSize, dstSize int, mode CoordMode) float32 {
+	if mode == CornerAligned {
+		if dstSize == 1 {
+			return float32(srcSize-1) / 2 // no corners to align: take the middle
+		}
+		return float32(dst) * float32(srcSize-1) / float32(dstSize-1)
+	}
+	return (float32(dst)+0.5)*float32(srcSize)/float32(dstSize) - 0.5
+}
+
+// Map each destination pixel (in one dimension) onto the two source pixels surrounding it.
+// Returns the coordinates of the lower source pixel, and of the destination pixel itself, in source space.
+// The coordinates are clamped to the source, so that the last row/column doesn't read out of bounds.
+func bilinearCoords(srcSize, dstSize int, mode CoordMode) (lower []int, pos []float32) {
+	lower, pos = make([]int, dstSize), make([]float32, dstSize)
+	maxPos := float32(srcSize - 1)
+	for d := 0; d < dstSize; d++ {
+		p := float32(math.Max(0, math.Min(float64(maxPos), float64(sourceCoord(d, srcSize, dstSize, mode)))))
+		lower[d], pos[d] = int(p), p
+	}
+	return
+}
+
+// ResizeBilinear resizes the image to the given dimensions, using bilinear interpolation.
+// Each destination pixel is computed from the four source pixels surrounding it.
+// Works for both upscaling and downscaling, though downscaling by large factors will alias,
+// as only four source pixels contribute to each destination pixel.
+// The mode selects how destination pixels map onto the source (see CoordMode).
+// Returns a new image (the original is not modified).
+func (img *FloatImage) ResizeBilinear(newWidth, newHeight int, mode CoordMode) *FloatImage {
+	return img.ResizeBilinearProgress(newWidth, newHeight, mode, nil)
+}
+
+// ResizeBilinearProgress resizes the image as per ResizeBilinear,
+// reporting the progress (e.g. for large images) as each row of the result is completed.
+// Returns a new image (the original is not modified).
+func (img *FloatImage) ResizeBilinearProgress(newWidth, newHeight int, mode CoordMode, progress Progress) *FloatImage {
+	if newWidth <= 0 || newHeight <= 0 || img.Width <= 0 || img.Height <= 0 {
+		return NewFloatImage(0, 0)
+	}
+
+	res := NewFloatImage(newWidth, newHeight)
+	res.Space = img.Space
+	xLower, xPos := bilinearCoords(img.Width, newWidth, mode)
+	yLower, yPos := bilinearCoords(img.Height, newHeight, mode)
+
+	for i := 0; i < 3; i++ {
+		src, dst := img.Ip[i], res.Ip[i]
+		for y := 0; y < newHeight; y++ {
+			// rows of the two surrounding pixels (clamped at the bottom edge)
+			y0 := yLower[y]
+			row0 := y0 * img.Width
+			row2 := clampPlaneExtension(y0+1, img.Height) * img.Width
+
+			for x := 0; x < newWidth; x++ {
+				x0 := xLower[x]
+				x2 := clampPlaneExtension(x0+1, img.Width)
+				dst[y*newWidth+x] = bilerp(
+					float32(x0), xPos[x], float32(x0+1),
+					float32(y0), yPos[y], float32(y0+1),
+					src[row0+x0], src[row2+x0], src[row0+x2], src[row2+x2])
+			}
+			progress.report(i*newHeight+y+1, 3*newHeight)
+		}
+	}
+
+	return res
+}
+
+// Map each destination pixel (in one dimension) onto the four source pixels surrounding it,
+// for cubic interpolation. Returns the indices of the four source pixels (clamped to the source,
+// so that the outer ring is available at the edges), and the position of the destination pixel
+// relative to the second of these, in source space (i.e. in the range [0,1)).
+func bicubicCoords(srcSize, dstSize int, mode CoordMode) (indices [][4]int, frac []float32) {
+	indices, frac = make([][4]int, dstSize), make([]float32, dstSize)
+	maxPos := float64(srcSize - 1)
+	for d := 0; d < dstSize; d++ {
+		p := math.Max(0, math.Min(maxPos, float64(sourceCoord(d, srcSize, dstSize, mode))))
+		p1 := int(p)
+		for k := 0; k < 4; k++ {
+			indices[d][k] = clampPlaneExtension(p1+k-1, srcSize)
+		}
+		frac[d] = float32(p - float64(p1))
+	}
+	return
+}
+
+// ResizeBicubic resizes the image to the given dimensions, using bicubic interpolation
+// (with the Catmull-Rom filter). See ResizeCubic for details.
+// Returns a new image (the original is not modified).
+func (img *FloatImage) ResizeBicubic(newWidth, newHeight int, dering bool, mode CoordMode) *FloatImage {
+	return img.ResizeCubic(newWidth, newHeight, CatmullRom, dering, mode)
+}
+
+// ResizeCubic resizes the image to the given dimensions, using bicubic interpolation
+// with the given cubic filter (e.g. CatmullRom, Mitchell, or BSpline).
+// Each destination pixel is computed from the 4x4 grid of source pixels surrounding it,
+// giving smoother results than bilinear interpolation.
+// Each of the three planes is interpolated independently.
+// Sharp filters (C > 0) overshoot near high-contrast edges, producing ringing (halos).
+// If dering is set, each destination pixel is clamped to the range of its 4x4 source
+// neighbourhood, which removes the ringing.
+// The mode selects how destination pixels map onto the source (see CoordMode).
+// Returns a new image (the original is not modified).
+func (img *FloatImage) ResizeCubic(newWidth, newHeight int, filter CubicFilter, dering bool, mode CoordMode) *FloatImage {
+	return img.ResizeCubicProgress(newWidth, newHeight, filter, dering, mode, nil)
+}
+
+// ResizeCubicProgress resizes the image as per ResizeCubic,
+// reporting the progress (e.g. for large images) as each row of the result is completed.
+// Returns a new image (the original is not modified).
+func (img *FloatImage) ResizeCubicProgress(newWidth, newHeight int, filter CubicFilter, dering bool, mode CoordMode, progress Progress) *FloatImage {
+	if newWidth <= 0 || newHeight <= 0 || img.Width <= 0 || img.Height <= 0 {
+		return NewFloatImage(0, 0)
+	}
+
+	res := NewFloatImage(newWidth, newHeight)
+	res.Space = img.Space
+	xs, xFrac := bicubicCoords(img.Width, newWidth, mode)
+	ys, yFrac := bicubicCoords(img.Height, newHeight, mode)
+
+	// the 4x4 source neighbourhood, in the order bicubicInterpolation takes it (i.e. column by column)
+	var n [16]float32
+
+	for i := 0; i < 3; i++ {
+		src, dst := img.Ip[i], res.Ip[i]
+		for y := 0; y < newHeight; y++ {
+			yp := yFrac[y]
+			for x := 0; x < newWidth; x++ {
+				for c := 0; c < 4; c++ {
+					for r := 0; r < 4; r++ {
+						n[c*4+r] = src[ys[y][r]*img.Width+xs[x][c]]
+					}
+				}
+
+				v := bicubicInterpolation(
+					-1, 0, xFrac[x], 1, 2,
+					-1, 0, yp, 1, 2,
+					n[0], n[1], n[2], n[3],
+					n[4], n[5], n[6], n[7],
+					n[8], n[9], n[10], n[11],
+					n[12], n[13], n[14], n[15],
+					filter)
+
+				if dering {
+					lo, hi := n[0], n[0]
+					for _, nv := range n[1:] {
+						if nv < lo {
+							lo = nv
+						} else if nv > hi {
+							hi = nv
+						}
+					}
+					if v < lo {
+						v = lo
+					} else if v > hi {
+						v = hi
+					}
+				}
+				dst[y*newWidth+x] = v
+			}
+			progress.report(i*newHeight+y+1, 3*newHeight)
+		}
+	}
+
+	return res
+}
+
+// Map each destination pixel (in one dimension) onto the source pixel whose area contains its center.
+func nearestCoords(srcSize, dstSize int, mode CoordMode) []int {
+	indices := make([]int, dstSize)
+	for d := 0; d < dstSize; d++ {
+		indices[d] = clampPlaneExtension(int(math.Floor(float64(sourceCoord(d, srcSize, dstSize, mode))+0.5)), srcSize)
+	}
+	return indices
+}
+
+// ResizeNearest resizes the image to the given dimensions, using nearest-neighbour sampling.
+// Each destination pixel takes the value of the source pixel containing its center, without any interpolation.
+// This is much faster than bilinear/bicubic resizing, and preserves hard edges
+// (e.g. for pixel art) when upscaling by integer multiples.
+// The mode selects how destination pixels map onto the source (see CoordMode).
+// Returns a new image (the original is not modified).
+func (img *FloatImage) ResizeNearest(newWidth, newHeight int, mode CoordMode) *FloatImage {
+	if newWidth <= 0 || newHeight <= 0 || img.Width <= 0 || img.Height <= 0 {
+		return NewFloatImage(0, 0)
+	}
+
+	res := NewFloatImage(newWidth, newHeight)
+	res.Space = img.Space
+	xs := nearestCoords(img.Width, newWidth, mode)
+	ys := nearestCoords(img.Height, newHeight, mode)
+
+	for i := 0; i < 3; i++ {
+		src, dst := img.Ip[i], res.Ip[i]
+		for y := 0; y < newHeight; y++ {
+			row := ys[y] * img.Width
+			for x := 0; x < newWidth; x++ {
+				dst[y*newWidth+x] = src[row+xs[x]]
+			}
+		}
+	}
+
+	return res
+}
+
+// Map each destination pixel (in one dimension) onto the weights of the source pixels contributing to it,
+// when bilinearly sampling the source after blurring it with the (1D) Gaussian weights, with the edges clamped.
+// The blur is only evaluated around the two source pixels surrounding each destination pixel,
+// with the weights of any clamped (out of bounds) pixels folded onto the edge pixel.
+// Returns the first contributing source pixel, and the weights of the consecutive source pixels from there.
+func preblurWeights(srcSize, dstSize int, mode CoordMode, gauss []float32) (start []int, weights [][]float32) {
+	radius := len(gauss) / 2
+	lower, pos := bilinearCoords(srcSize, dstSize, mode)
+	start, weights = make([]int, dstSize), make([][]float32, dstSize)
+	for d := 0; d < dstSize; d++ {
+		x0 := lower[d]
+		x2 := clampPlaneExtension(x0+1, srcSize)
+		frac := pos[d] - float32(x0) // bilinear weight of x2 (and 1-frac of x0)
+
+		first, last := clampPlaneExtension(x0-radius, srcSize), clampPlaneExtension(x0+1+radius, srcSize)
+		w := make([]float32, last-first+1)
+		for k := -radius; k <= radius; k++ {
+			w[clampPlaneExtension(x0+k, srcSize)-first] += (1 - frac) * gauss[k+radius]
+			w[clampPlaneExtension(x2+k, srcSize)-first] += frac * gauss[k+radius]
+		}
+		start[d], weights[d] = first, w
+	}
+	return
+}
+
+// ResizeWithPreblur resizes the image to the given dimensions, using bilinear interpolation,
+// of the image after an anti-aliasing Gaussian blur suited to the amount of downscaling.
+// A plain bilinear downscale only samples four source pixels per destination pixel,
+// so fine detail (e.g. a checkerboard) aliases into false patterns; the blur removes
+// the detail which is too fine to be represented at the new size.
+// The blur's sigma is chosen from the (larger) downscale factor s as sqrt(s^2 - 1) / 2,
+// and no blur is applied when upscaling.
+// The blur and the resize are done in a single (separable) pass: the blur is only evaluated at
+// the destination pixels, rather than over the whole source image. The result is the same as
+// ConvolveClamp with a GaussianFilterKernel followed by ResizeBilinear, but much cheaper.
+// The mode selects how destination pixels map onto the source, as per ResizeBilinear.
+// Returns a new image (the original is not modified).
+func (img *FloatImage) ResizeWithPreblur(newWidth, newHeight int, mode CoordMode) *FloatImage {
+	return img.ResizeWithPreblurProgress(newWidth, newHeight, mode, nil)
+}
+
+// ResizeWithPreblurProgress resizes the image as per ResizeWithPreblur,
+// reporting the progress (e.g. for large images) after each row of each pass over each plane.
+// Returns a new image (the original is not modified).
+func (img *FloatImage) ResizeWithPreblurProgress(newWidth, newHeight int, mode CoordMode, progress Progress) *FloatImage {
+	if newWidth <= 0 || newHeight <= 0 || img.Width <= 0 || img.Height <= 0 {
+		return NewFloatImage(0, 0)
+	}
+
+	scale := math.Max(float64(img.Width)/float64(newWidth), float64(img.Height)/float64(newHeight))
+	if scale <= 1 {
+		return img.ResizeBilinearProgress(newWidth, newHeight, mode, progress)
+	}
+
+	variance := (scale*scale - 1) / 4
+	gauss := Gaussian1DKernel(int(math.Ceil(3*math.Sqrt(variance))), variance)
+	xStart, xWeights := preblurWeights(img.Width, newWidth, mode, gauss)
+	yStart, yWeights := preblurWeights(img.Height, newHeight, mode, gauss)
+
+	res := NewFloatImage(newWidth, newHeight)
+	res.Space = img.Space
+	rows := make([]float32, newWidth*img.Height) // each source row, resampled to the new width
+	done, total := 0, 3*(img.Height+newHeight)   // rows of both passes, over each plane
+	for i := 0; i < 3; i++ {
+		src, dst := img.Ip[i], res.Ip[i]
+
+		// horizontal pass:
+		for y := 0; y < img.Height; y++ {
+			row := src[y*img.Width : (y+1)*img.Width]
+			for x := 0; x < newWidth; x++ {
+				sum := float32(0)
+				for k, w := range xWeights[x] {
+					sum += w * row[xStart[x]+k]
+				}
+				rows[y*newWidth+x] = sum
+			}
+			done++
+			progress.report(done, total)
+		}
+
+		// then, the vertical pass:
+		for y := 0; y < newHeight; y++ {
+			for x := 0; x < newWidth; x++ {
+				sum := float32(0)
+				for k, w := range yWeights[y] {
+					sum += w * rows[(yStart[y]+k)*newWidth+x]
+				}
+				dst[y*newWidth+x] = sum
+			}
+			done++
+			progress.report(done, total)
+		}
+	}
+
+	return res
+}
+
+// round a (scaled) dimension to the nearest multiple of multiple (halves round up),
+// keeping at least one multiple. A multiple <= 1 rounds to the nearest pixel.
+func snapDimension(dim float64, multiple int) int {
+	if multiple < 1 {
+		multiple = 1
+	}
+	m := float64(multiple)
+	return int(math.Max(1, math.Floor(dim/m+0.5))) * multiple
+}
+
+// ResizeSnapped scales the image by the given factor (using ResizeBilinear), except that each
+// of the new dimensions is rounded to the nearest multiple of multiple: e.g. 2 for the even
+// dimensions required by many video codecs, or 16 for their macroblocks.
+// The aspect ratio may therefore change slightly.
+// Returns a new image (the original is not modified).
+func (img *FloatImage) ResizeSnapped(scale float64, multiple int, mode CoordMode) *FloatImage {
+	if scale <= 0 || img.Width <= 0 || img.Height <= 0 {
+		return NewFloatImage(0, 0)
+	}
+	newWidth := snapDimension(float64(img.Width)*scale, multiple)
+	newHeight := snapDimension(float64(img.Height)*scale, multiple)
+	return img.ResizeBilinear(newWidth, newHeight, mode)
 }
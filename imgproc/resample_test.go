@@ -0,0 +1,280 @@
+// Test file for resample.go
+
+package imgproc
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"testing"
+)
+
+// build a gray image (all planes equal) from the given row-major values
+func grayImage(width, height int, vals ...float32) *FloatImage {
+	img := NewFloatImage(width, height)
+	for i := 0; i < 3; i++ {
+		copy(img.Ip[i], vals)
+	}
+	return img
+}
+
+func TestBilerpOfCorners(t *testing.T) {
+	f00, f02, f20, f22 := float32(1), float32(2), float32(3), float32(4)
+	assertFloat32Equals(t, f00, bilerp(0, 0, 1, 0, 0, 1, f00, f02, f20, f22), "bilerp(x0,y0)")
+	assertFloat32Equals(t, f02, bilerp(0, 0, 1, 0, 1, 1, f00, f02, f20, f22), "bilerp(x0,y2)")
+	assertFloat32Equals(t, f20, bilerp(0, 1, 1, 0, 0, 1, f00, f02, f20, f22), "bilerp(x2,y0)")
+	assertFloat32Equals(t, f22, bilerp(0, 1, 1, 0, 1, 1, f00, f02, f20, f22), "bilerp(x2,y2)")
+	assertFloat32Equals(t, 2.5, bilerp(0, 0.5, 1, 0, 0.5, 1, f00, f02, f20, f22), "bilerp(center)")
+}
+
+func TestResizeBilinearUpscale2x2To3x3(t *testing.T) {
+	img := grayImage(2, 2,
+		0, 10,
+		20, 30)
+	res := img.ResizeBilinear(3, 3, CenterAligned)
+
+	assertIntEquals(t, 3, res.Width, "ResizeBilinear.Width")
+	assertIntEquals(t, 3, res.Height, "ResizeBilinear.Height")
+	exp := []float32{
+		0, 5, 10,
+		10, 15, 20,
+		20, 25, 30}
+	for i := 0; i < 3; i++ {
+		assertFloat32SliceEquals(t, exp, res.Ip[i], "ResizeBilinear[2x2->3x3]")
+	}
+}
+
+func TestResizeBilinearDownscaleAveragesBlocks(t *testing.T) {
+	img := grayImage(4, 4,
+		0, 2, 10, 12,
+		4, 6, 14, 16,
+		20, 22, 30, 32,
+		24, 26, 34, 36)
+	res := img.ResizeBilinear(2, 2, CenterAligned)
+	assertFloat32SliceEquals(t, []float32{3, 13, 23, 33}, res.Ip[0], "ResizeBilinear[4x4->2x2]")
+}
+
+func TestResizeBilinearSameSizeIsIdentity(t *testing.T) {
+	img := sequentialImage(5, 3)
+	res := img.ResizeBilinear(5, 3, CenterAligned)
+	assertFloat32SliceEquals(t, img.Ip[1], res.Ip[1], "ResizeBilinear[1:1]")
+}
+
+func TestResizeBilinearOfSinglePixel(t *testing.T) {
+	img := grayImage(1, 1, 7)
+	res := img.ResizeBilinear(2, 3, CenterAligned)
+	assertFloat32SliceEquals(t, []float32{7, 7, 7, 7, 7, 7}, res.Ip[2], "ResizeBilinear[1x1->2x3]")
+}
+
+func TestResizeBicubicSameSizeIsIdentity(t *testing.T) {
+	img := sequentialImage(5, 4)
+	res := img.ResizeBicubic(5, 4, false, CenterAligned)
+	for i := 0; i < 3; i++ {
+		assertFloat32SliceEquals(t, img.Ip[i], res.Ip[i], "ResizeBicubic[1:1]")
+	}
+}
+
+func TestResizeBicubicOfConstantImage(t *testing.T) {
+	img := grayImage(2, 2, 9, 9, 9, 9)
+	res := img.ResizeBicubic(5, 3, false, CenterAligned)
+	for i, v := range res.Ip[0] {
+		assert(t, math.Abs(float64(v)-9) < 1e-5, "ResizeBicubic[constant]["+strconv.Itoa(i)+"]")
+	}
+}
+
+func TestResizeBicubicIsSharperThanBilinear(t *testing.T) {
+	// a single row, stepping from 0 to 1 between source pixels 3 and 4
+	img := grayImage(8, 1, 0, 0, 0, 0, 1, 1, 1, 1)
+	bicubic := img.ResizeBicubic(32, 1, false, CenterAligned)
+	bilinear := img.ResizeBilinear(32, 1, CenterAligned)
+
+	// destination pixels 14 to 17 lie between source pixels 3 and 4, and
+	// bicubic should stay closer to the step on either side of the midpoint.
+	assert(t, bicubic.Ip[0][14] < bilinear.Ip[0][14], "ResizeBicubic should be darker than bilinear just after the dark side")
+	assert(t, bicubic.Ip[0][17] > bilinear.Ip[0][17], "ResizeBicubic should be brighter than bilinear just before the bright side")
+
+	// both agree exactly at the midpoint
+	assertFloat32Equals(t, bilinear.Ip[0][15]+bilinear.Ip[0][16], bicubic.Ip[0][15]+bicubic.Ip[0][16], "ResizeBicubic.midpoint")
+}
+
+func TestResizeNearestUpscaleKeepsCheckerboardCrisp(t *testing.T) {
+	img := checkerboardImage(4, 4, 1)
+	res := img.ResizeNearest(8, 8, CenterAligned)
+	exp := checkerboardImage(8, 8, 2)
+	for i := 0; i < 3; i++ {
+		assertFloat32SliceEquals(t, exp.Ip[i], res.Ip[i], "ResizeNearest[checkerboard x2]")
+	}
+}
+
+func TestResizeNearestDownscalePicksSourcePixels(t *testing.T) {
+	img := sequentialImage(4, 4)
+	res := img.ResizeNearest(2, 2, CenterAligned)
+	// pixel centers at source (1,1), (3,1), (1,3), (3,3)
+	assertFloat32SliceEquals(t, []float32{5, 7, 13, 15}, res.Ip[0], "ResizeNearest[4x4->2x2]")
+}
+
+func TestResizeNearestSameSizeIsIdentity(t *testing.T) {
+	img := sequentialImage(3, 5)
+	res := img.ResizeNearest(3, 5, CenterAligned)
+	assertFloat32SliceEquals(t, img.Ip[2], res.Ip[2], "ResizeNearest[1:1]")
+}
+
+// find the smallest and largest values in a plane
+func planeRange(plane []float32) (lo, hi float32) {
+	lo, hi = plane[0], plane[0]
+	for _, v := range plane {
+		if v < lo {
+			lo = v
+		} else if v > hi {
+			hi = v
+		}
+	}
+	return
+}
+
+func TestResizeBicubicDeringStaysWithinSourceRange(t *testing.T) {
+	img := grayImage(8, 1, 0, 0, 0, 0, 1, 1, 1, 1)
+
+	// plain bicubic overshoots on both sides of the step
+	lo, hi := planeRange(img.ResizeBicubic(32, 1, false, CenterAligned).Ip[0])
+	assert(t, lo < 0, "ResizeBicubic should undershoot below a step edge")
+	assert(t, hi > 1, "ResizeBicubic should overshoot above a step edge")
+
+	// deringing keeps the result within the source range
+	lo, hi = planeRange(img.ResizeBicubic(32, 1, true, CenterAligned).Ip[0])
+	assert(t, lo >= 0, "ResizeBicubic[dering] should not undershoot")
+	assert(t, hi <= 1, "ResizeBicubic[dering] should not overshoot")
+}
+
+func TestResizeBicubicDeringMatchesPlainOnSmoothImage(t *testing.T) {
+	// a linear ramp has no ringing, so deringing should change nothing
+	img := sequentialImage(6, 1)
+	plain, deringed := img.ResizeBicubic(15, 1, false, CenterAligned), img.ResizeBicubic(15, 1, true, CenterAligned)
+	assertFloat32SliceEquals(t, plain.Ip[0], deringed.Ip[0], "ResizeBicubic[dering,ramp]")
+}
+
+// the (precomputed) Keys cubic convolution formula, with a=-0.5, for a point t in [0,1) between f1 and f3.
+func keysCubic(t, f0, f1, f3, f4 float32) float32 {
+	res := 3*(f1-f3) - f0 + f4
+	res *= t
+	res += 2*f0 - 5*f1 + 4*f3 - f4
+	res *= t
+	res += f3 - f0
+	res *= float32(0.5) * t
+	return res + f1
+}
+
+func TestCatmullRomMatchesKeysCubic(t *testing.T) {
+	vals := [][4]float32{{0, 0, 1, 1}, {1, 0, 1, 0}, {3, -2, 5, 7}, {100, 200, 50, 25}}
+	for _, f := range vals {
+		for step := 0; step <= 8; step++ {
+			x2 := float32(step) / 8
+			exp := keysCubic(x2, f[0], f[1], f[2], f[3])
+			act := cubicInterpolation(-1, 0, x2, 1, 2, f[0], f[1], f[2], f[3], CatmullRom)
+			assert(t, math.Abs(float64(exp-act)) < 1e-5, fmt.Sprintf("CatmullRom(t=%f, f=%v): exp=%f, act=%f", x2, f, exp, act))
+		}
+	}
+}
+
+func TestCubicFilterWeightsSumToOne(t *testing.T) {
+	for _, filter := range []CubicFilter{CatmullRom, Mitchell, BSpline} {
+		for step := 0; step <= 4; step++ {
+			x2 := float32(step) / 4
+			v := cubicInterpolation(-1, 0, x2, 1, 2, 1, 1, 1, 1, filter)
+			assert(t, math.Abs(float64(v)-1) < 1e-5, fmt.Sprintf("CubicFilter%v.sum(t=%f): act=%f", filter, x2, v))
+		}
+	}
+}
+
+func TestResizeCubicBSplineIsSmootherThanCatmullRom(t *testing.T) {
+	img := grayImage(8, 1, 0, 0, 0, 0, 1, 1, 1, 1)
+	sharp := img.ResizeCubic(32, 1, CatmullRom, false, CenterAligned)
+	smooth := img.ResizeCubic(32, 1, BSpline, false, CenterAligned)
+
+	// the B-spline spreads the step out, so it has a smaller largest step between neighbours
+	sharpStep, smoothStep := maxHorizontalStep(sharp.Ip[0], 32, 1), maxHorizontalStep(smooth.Ip[0], 32, 1)
+	assert(t, smoothStep < sharpStep, fmt.Sprintf("BSpline should be smoother: bspline-step=%f, catmull-rom-step=%f", smoothStep, sharpStep))
+
+	// and never overshoots
+	lo, hi := planeRange(smooth.Ip[0])
+	assert(t, lo >= 0 && hi <= 1, "BSpline should not overshoot")
+}
+
+func TestResizeBicubicUsesCatmullRom(t *testing.T) {
+	img := sequentialImage(4, 3)
+	assertFloat32SliceEquals(t, img.ResizeCubic(7, 5, CatmullRom, false, CenterAligned).Ip[0], img.ResizeBicubic(7, 5, false, CenterAligned).Ip[0], "ResizeBicubic")
+}
+
+func TestResizeWithPreblurReducesAliasing(t *testing.T) {
+	// a downscale by 3 samples single pixels of a fine checkerboard, giving a full-contrast (aliased) pattern
+	img := checkerboardImage(30, 30, 1)
+	plainLo, plainHi := planeRange(img.ResizeBilinear(10, 10, CenterAligned).Ip[0])
+	blurLo, blurHi := planeRange(img.ResizeWithPreblur(10, 10, CenterAligned).Ip[0])
+
+	plainContrast, blurContrast := plainHi-plainLo, blurHi-blurLo
+	assert(t, blurContrast*4 < plainContrast,
+		fmt.Sprintf("ResizeWithPreblur should suppress aliasing: preblur-contrast=%f, plain-contrast=%f", blurContrast, plainContrast))
+}
+
+func TestResizeWithPreblurMatchesBlurThenBilinear(t *testing.T) {
+	img := checkerboardImage(36, 24, 2)
+	for _, mode := range []CoordMode{CenterAligned, CornerAligned} {
+		// downscale by 3 (so sigma is sqrt(2), and the variance is 2)
+		exp := img.ConvolveClamp(GaussianFilterKernel(5, 2)).ResizeBilinear(12, 8, mode)
+		act := img.ResizeWithPreblur(12, 8, mode)
+		for i := 0; i < 3; i++ {
+			assertPlanesClose(t, exp.Ip[i], act.Ip[i], 1, fmt.Sprintf("ResizeWithPreblur[mode=%d][plane %d]", mode, i))
+		}
+	}
+}
+
+func TestResizeWithPreblurUpscaleMatchesBilinear(t *testing.T) {
+	img := sequentialImage(3, 3)
+	assertFloat32SliceEquals(t, img.ResizeBilinear(5, 7, CenterAligned).Ip[0], img.ResizeWithPreblur(5, 7, CenterAligned).Ip[0], "ResizeWithPreblur[upscale]")
+}
+
+func TestResizeNearestCoordModesDifferAtEdges(t *testing.T) {
+	img := grayImage(8, 1, 0, 1, 2, 3, 4, 5, 6, 7)
+
+	// pixel centers land at 0.5, 2.5, 4.5, 6.5: rounded up, so the first source pixel is skipped
+	centered := img.ResizeNearest(4, 1, CenterAligned)
+	assertFloat32SliceEquals(t, []float32{1, 3, 5, 7}, centered.Ip[0], "ResizeNearest[CenterAligned]")
+
+	// corners map onto corners: 0, 2.33, 4.67, 7
+	cornered := img.ResizeNearest(4, 1, CornerAligned)
+	assertFloat32SliceEquals(t, []float32{0, 2, 5, 7}, cornered.Ip[0], "ResizeNearest[CornerAligned]")
+}
+
+func TestResizeBilinearCornerAlignedKeepsCorners(t *testing.T) {
+	img := grayImage(4, 1, 0, 1, 2, 3)
+	res := img.ResizeBilinear(7, 1, CornerAligned)
+	assertFloat32SliceEquals(t, []float32{0, 0.5, 1, 1.5, 2, 2.5, 3}, res.Ip[0], "ResizeBilinear[CornerAligned]")
+
+	// whereas with center alignment, the outer half-pixels are clamped onto the edge values
+	res = img.ResizeBilinear(8, 1, CenterAligned)
+	assertFloat32SliceEquals(t, []float32{0, 0.25, 0.75, 1.25, 1.75, 2.25, 2.75, 3}, res.Ip[0], "ResizeBilinear[CenterAligned]")
+}
+
+func TestResizeSnappedGivesEvenDimensions(t *testing.T) {
+	img := NewFloatImage(101, 99)
+
+	// 50.5 x 49.5: the nearest even dimensions are 50 x 50
+	res := img.ResizeSnapped(0.5, 2, CenterAligned)
+	assertIntEquals(t, 50, res.Width, "ResizeSnapped[0.5,mod 2].Width")
+	assertIntEquals(t, 50, res.Height, "ResizeSnapped[0.5,mod 2].Height")
+
+	// 101 x 99: equally close to the even numbers either side, so round up
+	res = img.ResizeSnapped(1, 2, CenterAligned)
+	assertIntEquals(t, 102, res.Width, "ResizeSnapped[1,mod 2].Width")
+	assertIntEquals(t, 100, res.Height, "ResizeSnapped[1,mod 2].Height")
+}
+
+func TestResizeSnappedToMultipleOf16(t *testing.T) {
+	res := NewFloatImage(101, 99).ResizeSnapped(2, 16, CenterAligned)
+	assertIntEquals(t, 208, res.Width, "ResizeSnapped[2,mod 16].Width")
+	assertIntEquals(t, 192, res.Height, "ResizeSnapped[2,mod 16].Height")
+
+	// never snapped down to nothing
+	res = NewFloatImage(4, 4).ResizeSnapped(0.5, 16, CenterAligned)
+	assertIntEquals(t, 16, res.Width, "ResizeSnapped[tiny].Width")
+}
@@ -4,15 +4,22 @@ package imgproc
 import "math"
 
 // Sharpen the image with the given amount, radius and threshold, using
-// the Unsharp mask technique.
+// the Unsharp mask technique. The result is clamped into [0,65535].
 // mutates the current image.
 func (img *FloatImage) Unsharp(radius int, amount, threshold float64) {
+	img.UnsharpEdge(radius, amount, threshold, EdgeClamp)
+}
+
+// Sharpen the image as per FloatImage.Unsharp, except with the edges
+// of the blur handled as per the given mode (see EdgeMode).
+// mutates the current image.
+func (img *FloatImage) UnsharpEdge(radius int, amount, threshold float64, mode EdgeMode) {
 
 	// TODO - possibly convert to HSV, apply transform on value only, convert back
 
 	// apply gaussian blur
 	gaussKernel := GaussianFilterKernel(radius, amount)
-	blurImg := img.ConvolveClamp(gaussKernel)
+	blurImg := img.ConvolveEdge(gaussKernel, mode)
 
 	// if diff(orig, blur) > threshold, apply subtraction
 	unsharpFn := func(vals ...float32) float32 {
@@ -24,6 +31,7 @@ func (img *FloatImage) Unsharp(radius int, amount, threshold float64) {
 	}
 
 	img.Apply(unsharpFn, blurImg)
+	img.Clamp() // the sharpened edges overshoot
 }
 
 // Sharpen the image as per FloatImage.Unsharp, except return a new image 
@@ -34,13 +42,21 @@ func Unsharp(img *FloatImage, radius int, amount, threshold float64) *FloatImage
 	return result
 }
 
-// Sharpen the image using the Laplacian.
+// Sharpen the image using the Laplacian. The result is clamped into [0,65535].
+// This increases the brightness: apply EqualizeLuma afterwards to compensate, if required.
 // Modifies the current image.
 func (img *FloatImage) SharpenLaplace() {
+	img.SharpenLaplaceEdge(EdgeClamp)
+}
+
+// Sharpen the image using the Laplacian, as per FloatImage.SharpenLaplace,
+// except with the edges handled as per the given mode (see EdgeMode).
+// Modifies the current image.
+func (img *FloatImage) SharpenLaplaceEdge(mode EdgeMode) {
 	// add laplacian to the image
-	laplacian := img.ConvolveClamp(LaplaceSpherical())
+	laplacian := img.ConvolveEdge(LaplaceSpherical(), mode)
 	img.Apply(func(v ...float32) float32 { return v[0] + v[1] }, laplacian)
-	// TODO histogram equalisation, to compensate for the increased brightness.
+	img.Clamp() // the sharpened edges overshoot
 }
 
 // Sharpen the image using the Laplacian.